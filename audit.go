@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+)
+
+// Actions recorded in the audit log
+const (
+	auditLogin               = "auth.login"
+	auditLoginFailed         = "auth.login_failed"
+	auditMFAEnabled          = "auth.mfa_enabled"
+	auditPasswordReset       = "auth.password_reset"
+	auditSessionRevoked      = "auth.session_revoked"
+	auditSessionsRevoked     = "auth.sessions_revoked"
+	auditAPIKeyCreated       = "auth.api_key_created"
+	auditAPIKeyDeleted       = "auth.api_key_deleted"
+	auditWebhookCreated      = "webhook.endpoint_created"
+	auditWebhookDeleted      = "webhook.endpoint_deleted"
+	auditUserUpdated         = "user.updated"
+	auditUserDeleted         = "user.deleted"
+	auditUserRestored        = "user.restored"
+	auditUserPurged          = "user.purged"
+	auditAdminReset          = "admin.reset"
+	auditRoleChanged         = "admin.role_changed"
+	auditUserSuspended       = "admin.user_suspended"
+	auditUserUnsuspended     = "admin.user_unsuspended"
+	auditWebhookReplayed     = "admin.webhook_replayed"
+	auditJobRetried          = "admin.job_retried"
+	auditLogExported         = "admin.audit_exported"
+	auditWordListChanged     = "admin.word_list_changed"
+	auditChirpFlagged        = "moderation.chirp_flagged"
+	auditSubscriptionChanged = "billing.subscription_changed"
+)
+
+// Types of audit target
+const (
+	targetUser         = "user"
+	targetEmail        = "email"
+	targetSession      = "session"
+	targetAPIKey       = "api_key"
+	targetWebhook      = "webhook_endpoint"
+	targetDatabase     = "database"
+	targetWebhookEvent = "webhook_event"
+	targetJob          = "job"
+	targetAuditLog     = "audit_log"
+	targetWordList     = "word_list"
+	targetChirp        = "chirp"
+)
+
+type auditOriginKey struct{}
+
+// auditOrigin is who an action is attributed to, carried in the context down to where it's recorded
+type auditOrigin struct {
+	actor     audit.Actor
+	ip        string
+	userAgent string
+	reason    string
+}
+
+// withAuditActor attributes the actions recorded with the returned context to actor, from the
+// request's ip and user agent
+func withAuditActor(r *http.Request, actor audit.Actor) context.Context {
+	return context.WithValue(r.Context(), auditOriginKey{}, auditOrigin{
+		actor:     actor,
+		ip:        clientIP(r),
+		userAgent: r.UserAgent(),
+	})
+}
+
+// withAuditReason adds what caused the actions recorded with the returned context
+func withAuditReason(ctx context.Context, reason string) context.Context {
+	origin := auditOriginFrom(ctx)
+	origin.reason = reason
+	return context.WithValue(ctx, auditOriginKey{}, origin)
+}
+
+// auditOriginFrom is the origin set on ctx, or the system when there is none, as in background work
+func auditOriginFrom(ctx context.Context) auditOrigin {
+	origin, ok := ctx.Value(auditOriginKey{}).(auditOrigin)
+	if !ok {
+		return auditOrigin{actor: audit.Actor{Type: audit.ActorSystem}}
+	}
+	return origin
+}
+
+// recordAudit appends an action to the audit log. Failing to record it is logged rather than
+// failing an action that already happened.
+func (cfg *apiConfig) recordAudit(ctx context.Context, action string, target audit.Target, changes []audit.Change) {
+	origin := auditOriginFrom(ctx)
+	_, err := cfg.database.AppendAuditEntry(audit.Entry{
+		Actor:     origin.actor,
+		Action:    action,
+		Target:    target,
+		IP:        origin.ip,
+		UserAgent: origin.userAgent,
+		Reason:    origin.reason,
+		Changes:   changes,
+	})
+	if err != nil {
+		log.Printf("Failed to record %s on %s %s in the audit log: %s", action, target.Type, target.ID, err)
+	}
+}
+
+// auditRequest records an action actor took with the request
+func (cfg *apiConfig) auditRequest(r *http.Request, actor audit.Actor, action string, target audit.Target, changes []audit.Change) {
+	cfg.recordAudit(withAuditActor(r, actor), action, target, changes)
+}
+
+// requestActor is the signed in user making the request, or anonymous
+func (cfg *apiConfig) requestActor(r *http.Request) audit.Actor {
+	claims, err := cfg.getAuthClaims(r)
+	if err != nil {
+		return audit.Actor{Type: audit.ActorAnonymous}
+	}
+	return audit.Actor{Type: audit.ActorUser, ID: claims.Subject}
+}
+
+func userActor(userID int) audit.Actor {
+	return audit.Actor{Type: audit.ActorUser, ID: strconv.Itoa(userID)}
+}
+
+func userTarget(userID int) audit.Target {
+	return audit.Target{Type: targetUser, ID: strconv.Itoa(userID)}
+}
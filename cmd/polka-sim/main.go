@@ -0,0 +1,267 @@
+// Command polka-sim sends Polka webhooks to a running chirpy server, so the webhook path can be
+// tested without the real provider.
+//
+//	polka-sim list
+//	polka-sim send -event user.upgraded -user 1
+//	polka-sim run [scenario ...]
+//
+// Requests are signed with POLKA_WEBHOOK_SECRET and carry POLKA_API_KEY, both read from the
+// environment or a .env file, or given with -secret and -api-key. Scenarios check the user's
+// Chirpy Red status by logging in as them with -email and -password, and skip those checks without.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/webhook"
+	"github.com/joho/godotenv"
+)
+
+// Ways a request can be authenticated, all but authValid should be rejected
+const (
+	authValid = iota
+	authNone
+	authBadSignature
+	authStale
+)
+
+type client struct {
+	url      string
+	apiKey   string
+	secret   string
+	loginURL string
+	email    string
+	password string
+	http     *http.Client
+}
+
+type delivery struct {
+	id    string
+	event string
+	// when Polka created the event, left out of the body if zero
+	createdAt time.Time
+	data      map[string]interface{}
+	// raw replaces the json body built from the fields above
+	raw  string
+	auth int
+}
+
+func main() {
+	godotenv.Load()
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080/api/polka/webhooks", "Webhook url of the chirpy server")
+	apiKey := flags.String("api-key", os.Getenv("POLKA_API_KEY"), "Polka api key")
+	secret := flags.String("secret", os.Getenv("POLKA_WEBHOOK_SECRET"), "Polka webhook signing secret")
+	user := flags.String("user", "1", "Id of the user events are about, an integer for the json db or a uuid for postgres")
+	event := flags.String("event", "user.upgraded", "Event to send")
+	eventID := flags.String("id", "", "Event id, random if empty")
+	loginURL := flags.String("login-url", "http://localhost:8080/api/login", "Login url of the chirpy server")
+	email := flags.String("email", "", "Email of the user, to check their Chirpy Red status")
+	password := flags.String("password", "", "Password of the user")
+	flags.Parse(os.Args[2:])
+
+	c := client{
+		url:      *url,
+		apiKey:   *apiKey,
+		secret:   *secret,
+		loginURL: *loginURL,
+		email:    *email,
+		password: *password,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+
+	switch os.Args[1] {
+	case "list":
+		for _, s := range scenarios {
+			fmt.Printf("%-14s %s\n", s.name, s.description)
+		}
+	case "send":
+		id := *eventID
+		if id == "" {
+			id = newEventID()
+		}
+		status, body, err := c.send(delivery{id: id, event: *event, createdAt: time.Now(), data: map[string]interface{}{"user_id": userID(*user)}})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d %s\n", status, body)
+	case "run":
+		names := flags.Args()
+		if len(names) == 0 {
+			for _, s := range scenarios {
+				names = append(names, s.name)
+			}
+		}
+		if !c.run(names, userID(*user)) {
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: polka-sim list | send [flags] | run [flags] [scenario ...]")
+}
+
+// run plays the scenarios in order and reports whether every step got the expected status and
+// left the user with the expected Chirpy Red status
+func (c client) run(names []string, user interface{}) bool {
+	passed := true
+	for _, name := range names {
+		s, ok := findScenario(name)
+		if !ok {
+			fmt.Printf("FAIL %s: no such scenario\n", name)
+			passed = false
+			continue
+		}
+
+		for _, st := range s.steps(user) {
+			label := s.name + "/" + st.name
+			if st.delivery.auth != authValid && st.delivery.auth != authNone && c.secret == "" {
+				fmt.Printf("SKIP %s: needs a signing secret\n", label)
+				continue
+			}
+			status, body, err := c.send(st.delivery)
+			if err != nil {
+				fmt.Printf("FAIL %s: %s\n", label, err)
+				passed = false
+				continue
+			}
+			if status != st.expect {
+				fmt.Printf("FAIL %s: expected %d, got %d %s\n", label, st.expect, status, body)
+				passed = false
+				continue
+			}
+			fmt.Printf("PASS %s (%d)\n", label, status)
+
+			if st.premium == nil {
+				continue
+			}
+			if c.email == "" {
+				fmt.Printf("SKIP %s: checking Chirpy Red needs -email and -password\n", label)
+				continue
+			}
+			premium, err := c.premium()
+			if err != nil {
+				fmt.Printf("FAIL %s: %s\n", label, err)
+				passed = false
+				continue
+			}
+			if premium != *st.premium {
+				fmt.Printf("FAIL %s: expected is_chirpy_red %t, got %t\n", label, *st.premium, premium)
+				passed = false
+				continue
+			}
+			fmt.Printf("PASS %s (is_chirpy_red %t)\n", label, premium)
+		}
+	}
+	return passed
+}
+
+// send posts the delivery and returns the response status and body
+func (c client) send(d delivery) (int, string, error) {
+	body := []byte(d.raw)
+	if d.raw == "" {
+		var err error
+		payload := map[string]interface{}{
+			"id":    d.id,
+			"event": d.event,
+			"data":  d.data,
+		}
+		if !d.createdAt.IsZero() {
+			payload["created_at"] = d.createdAt.UTC().Format(time.RFC3339Nano)
+		}
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch d.auth {
+	case authValid:
+		if c.apiKey != "" {
+			req.Header.Set("Authorization", "ApiKey "+c.apiKey)
+		}
+		if c.secret != "" {
+			req.Header.Set("Polka-Signature", webhook.Sign(c.secret, body, time.Now()))
+		}
+	case authBadSignature:
+		req.Header.Set("Polka-Signature", webhook.Sign(c.secret+"-wrong", body, time.Now()))
+	case authStale:
+		req.Header.Set("Polka-Signature", webhook.Sign(c.secret, body, time.Now().Add(-time.Hour)))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode, string(respBody), nil
+}
+
+// premium logs in as the user and returns their Chirpy Red status
+func (c client) premium() (bool, error) {
+	body, err := json.Marshal(map[string]string{"email": c.email, "password": c.password})
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.http.Post(c.loginURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("login responded %s", resp.Status)
+	}
+
+	user := struct {
+		PremiumRed bool `json:"is_chirpy_red"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	if err != nil {
+		return false, err
+	}
+	return user.PremiumRed, nil
+}
+
+// userID sends integer ids as json numbers, as Polka does for json db users
+func userID(user string) interface{} {
+	if id, err := strconv.Atoi(user); err == nil {
+		return id
+	}
+	return user
+}
+
+func newEventID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
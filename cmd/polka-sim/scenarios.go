@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type scenario struct {
+	name        string
+	description string
+	// steps are built for each run so event ids are fresh
+	steps func(user interface{}) []step
+}
+
+type step struct {
+	name     string
+	delivery delivery
+	expect   int
+	// when set, whether the user must have Chirpy Red after the delivery
+	premium *bool
+}
+
+var (
+	red    = true
+	notRed = false
+)
+
+var scenarios = []scenario{
+	{
+		name:        "upgrade",
+		description: "upgrade a user",
+		steps: func(user interface{}) []step {
+			return []step{
+				{name: "upgraded", delivery: event("user.upgraded", user), expect: http.StatusNoContent, premium: &red},
+			}
+		},
+	},
+	{
+		name:        "lifecycle",
+		description: "upgrade, fail a payment, renew and downgrade",
+		steps: func(user interface{}) []step {
+			return []step{
+				{name: "upgraded", delivery: event("user.upgraded", user), expect: http.StatusNoContent, premium: &red},
+				{name: "payment failed", delivery: event("payment.failed", user), expect: http.StatusNoContent, premium: &red},
+				{name: "renewed", delivery: event("subscription.renewed", user), expect: http.StatusNoContent, premium: &red},
+				{name: "downgraded", delivery: event("user.downgraded", user), expect: http.StatusNoContent, premium: &notRed},
+			}
+		},
+	},
+	{
+		name:        "duplicates",
+		description: "deliver an upgrade again after a downgrade, the retry must be accepted without reapplying it",
+		steps: func(user interface{}) []step {
+			// Without created_at only the event id keeps the retry from being applied
+			upgraded := event("user.upgraded", user)
+			upgraded.createdAt = time.Time{}
+			downgraded := event("user.downgraded", user)
+			downgraded.createdAt = time.Time{}
+			return []step{
+				{name: "first delivery", delivery: upgraded, expect: http.StatusNoContent, premium: &red},
+				{name: "downgraded", delivery: downgraded, expect: http.StatusNoContent, premium: &notRed},
+				{name: "retried delivery", delivery: upgraded, expect: http.StatusNoContent, premium: &notRed},
+			}
+		},
+	},
+	{
+		name:        "out-of-order",
+		description: "events created before a downgrade but delivered after it don't reopen the subscription",
+		steps: func(user interface{}) []step {
+			// Created in order, moments apart, and later than any event of an earlier scenario
+			now := time.Now()
+			upgraded := event("user.upgraded", user)
+			upgraded.createdAt = now
+			// A plan change Polka created before the downgrade, delivered late
+			staleUpgrade := event("user.upgraded", user)
+			staleUpgrade.createdAt = now.Add(time.Millisecond)
+			downgraded := event("user.downgraded", user)
+			downgraded.createdAt = now.Add(2 * time.Millisecond)
+			return []step{
+				{name: "upgraded", delivery: upgraded, expect: http.StatusNoContent, premium: &red},
+				{name: "downgraded", delivery: downgraded, expect: http.StatusNoContent, premium: &notRed},
+				{name: "stale upgrade", delivery: staleUpgrade, expect: http.StatusNoContent, premium: &notRed},
+				{name: "late payment failure", delivery: event("payment.failed", user), expect: http.StatusNoContent, premium: &notRed},
+				{name: "late renewal", delivery: event("subscription.renewed", user), expect: http.StatusNoContent, premium: &notRed},
+			}
+		},
+	},
+	{
+		name:        "malformed",
+		description: "payloads that can never be applied are rejected",
+		steps: func(user interface{}) []step {
+			missingUser := event("user.upgraded", user)
+			missingUser.data = map[string]interface{}{}
+			wrongType := event("user.upgraded", user)
+			wrongType.data = map[string]interface{}{"user_id": true}
+			badPeriod := event("user.upgraded", user)
+			badPeriod.data["current_period_end"] = "next tuesday"
+			return []step{
+				{name: "not json", delivery: delivery{raw: "{not json", auth: authValid}, expect: http.StatusBadRequest},
+				{name: "missing user_id", delivery: missingUser, expect: http.StatusBadRequest},
+				{name: "user_id of the wrong type", delivery: wrongType, expect: http.StatusBadRequest},
+				{name: "invalid period end", delivery: badPeriod, expect: http.StatusBadRequest},
+				{name: "unknown event", delivery: event("user.renamed", user), expect: http.StatusNoContent},
+			}
+		},
+	},
+	{
+		name:        "auth",
+		description: "unauthenticated, forged and replayed deliveries are rejected",
+		steps: func(user interface{}) []step {
+			noAuth := event("user.upgraded", user)
+			noAuth.auth = authNone
+			forged := event("user.upgraded", user)
+			forged.auth = authBadSignature
+			stale := event("user.upgraded", user)
+			stale.auth = authStale
+			return []step{
+				{name: "no credentials", delivery: noAuth, expect: http.StatusUnauthorized},
+				{name: "wrong signature", delivery: forged, expect: http.StatusUnauthorized},
+				{name: "stale timestamp", delivery: stale, expect: http.StatusUnauthorized},
+			}
+		},
+	},
+}
+
+func event(name string, user interface{}) delivery {
+	return delivery{
+		id:        newEventID(),
+		event:     name,
+		createdAt: time.Now(),
+		data:      map[string]interface{}{"user_id": user},
+		auth:      authValid,
+	}
+}
+
+func findScenario(name string) (scenario, bool) {
+	for _, s := range scenarios {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return scenario{}, false
+}
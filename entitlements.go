@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ethpalser/chirpy/internal/billing"
+	"github.com/ethpalser/chirpy/internal/chirptext"
+	"github.com/ethpalser/chirpy/internal/database"
+	database2 "github.com/ethpalser/chirpy/internal/database/v2"
+	"github.com/ethpalser/chirpy/internal/entitlements"
+)
+
+// limitsFor returns the limits of the plan the user is subscribed to, or of the free plan
+func (cfg *apiConfig) limitsFor(dbUser database.User) entitlements.Limits {
+	if !dbUser.PremiumRed {
+		return cfg.entitlements.For(entitlements.PlanFree)
+	}
+	// Users upgraded before subscriptions were tracked have no subscription
+	sub, err := cfg.database.GetSubscription(dbUser.Id)
+	if err != nil || sub.Plan == "" {
+		return cfg.entitlements.For(billing.PlanRed)
+	}
+	return cfg.entitlements.For(sub.Plan)
+}
+
+// chirpRules are the rules the text of a chirp must follow under the limits
+func chirpRules(limits entitlements.Limits) chirptext.Rules {
+	return chirptext.Rules{
+		MaxLength: limits.MaxChirpLength,
+		Unit:      limits.ChirpLengthUnit,
+	}
+}
+
+// limitsForV2 is limitsFor for a postgres user
+func (cfg *apiConfig) limitsForV2(ctx context.Context, dbUser database2.User) entitlements.Limits {
+	if !dbUser.IsChirpyRed {
+		return cfg.entitlements.For(entitlements.PlanFree)
+	}
+	sub, err := cfg.dbQueries.GetSubscription(ctx, dbUser.ID)
+	if err != nil {
+		return cfg.entitlements.For(billing.PlanRed)
+	}
+	return cfg.entitlements.For(sub.Plan)
+}
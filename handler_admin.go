@@ -1,25 +1,210 @@
 package main
 
-import(
-	"net/http"
+import (
+	"encoding/json"
+	"errors"
 	"log"
-	"os"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
-	err := godotenv.Load()
+	if cfg.platform != "dev" {
+		responseWithError(w, http.StatusForbidden, "Forbidden 403")
+		return
+	}
+
+	err := cfg.dbQueries.DeleteAllUsers(r.Context())
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The json users would otherwise stay linked to postgres users that no longer exist
+	err = cfg.database.UnlinkPostgresUsers()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, cfg.requestActor(r), auditAdminReset, audit.Target{Type: targetDatabase, ID: "users"}, nil)
+	responseWithJSON(w, http.StatusOK, nil)
+}
+
+func (cfg *apiConfig) handlerAdminUsersRole(w http.ResponseWriter, r *http.Request) {
+	type RoleRequest struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
 	if err != nil {
-		log.Fatal("Fatal error: .env failed to load")	
+		responseWithError(w, http.StatusBadRequest, "invalid user id")
+		return
 	}
 
-	platform := os.Getenv("PLATFORM")
-	if platform != "dev" {
-		responseWithError(w, http.StatusForbidden, "Forbidden 403")
+	decoder := json.NewDecoder(r.Body)
+	params := RoleRequest{}
+	err = decoder.Decode(&params)
+	if err != nil || !auth.ValidRole(params.Role) {
+		responseWithError(w, http.StatusBadRequest, "role must be one of user, moderator or admin")
 		return
 	}
 
-	cfg.dbQueries.DeleteAllUsers(r.Context())
-	responseWithJSON(w, http.StatusOK, nil)
+	before, err := cfg.database.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = cfg.database.UpdateUserRole(userID, params.Role)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, cfg.requestActor(r), auditRoleChanged, userTarget(userID), audit.Diff(
+		map[string]interface{}{"role": before.Role},
+		map[string]interface{}{"role": params.Role},
+	))
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerAdminUsersSuspend suspends or bans a user, signing them out everywhere immediately
+func (cfg *apiConfig) handlerAdminUsersSuspend(w http.ResponseWriter, r *http.Request) {
+	type SuspendRequest struct {
+		Kind      string     `json:"kind"`
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	_, actor, err := cfg.getAuthUser(r)
+	if err != nil {
+		responseWithError(w, authErrorStatus(err), "unauthorized access")
+		return
+	}
+	actorID := actor.Id
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if userID == actorID {
+		responseWithError(w, http.StatusBadRequest, "you cannot suspend yourself")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := SuspendRequest{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if params.Kind != database.SuspensionSuspended && params.Kind != database.SuspensionBanned {
+		responseWithError(w, http.StatusBadRequest, "kind must be one of suspended or banned")
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.Reason == "" {
+		responseWithError(w, http.StatusBadRequest, "a reason is required")
+		return
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		responseWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	target, err := cfg.database.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !canModerate(actor, target) {
+		responseWithError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	suspension := database.Suspension{
+		Kind:      params.Kind,
+		Reason:    params.Reason,
+		ExpiresAt: params.ExpiresAt,
+		By:        actorID,
+		CreatedAt: time.Now(),
+	}
+	_, err = cfg.database.SuspendUser(userID, suspension)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(actorID), auditUserSuspended, userTarget(userID), []audit.Change{
+		{Field: "suspension", Before: target.Suspension, After: suspension},
+	})
+	log.Printf("User %d %s user %d: %s", actorID, params.Kind, userID, params.Reason)
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerAdminUsersUnsuspend lifts a user's suspension or ban early
+func (cfg *apiConfig) handlerAdminUsersUnsuspend(w http.ResponseWriter, r *http.Request) {
+	_, actor, err := cfg.getAuthUser(r)
+	if err != nil {
+		responseWithError(w, authErrorStatus(err), "unauthorized access")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	before, err := cfg.database.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !canModerate(actor, before) {
+		responseWithError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	_, err = cfg.database.LiftSuspension(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "user is not suspended")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(actor.Id), auditUserUnsuspended, userTarget(userID), []audit.Change{
+		{Field: "suspension", Before: before.Suspension, After: nil},
+	})
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// canModerate reports whether actor may suspend target or lift their suspension.
+// Moderators act on regular users, only an admin can act on other staff.
+func canModerate(actor database.User, target database.User) bool {
+	return target.Role == auth.RoleUser || actor.Role == auth.RoleAdmin
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/jobs"
+)
+
+// handlerAdminJobs lists the newest jobs, optionally filtered by ?status= and capped by ?limit=
+func (cfg *apiConfig) handlerAdminJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if val := r.URL.Query().Get("limit"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			responseWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = parsed
+	}
+
+	list, err := cfg.jobs.Store().ListJobs(r.Context(), r.URL.Query().Get("status"), limit)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	responseWithJSON(w, http.StatusOK, list)
+}
+
+func (cfg *apiConfig) handlerAdminJobsGet(w http.ResponseWriter, r *http.Request) {
+	job, err := cfg.jobs.Store().GetJob(r.Context(), r.PathValue("jobID"))
+	if errors.Is(err, jobs.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	responseWithJSON(w, http.StatusOK, job)
+}
+
+// handlerAdminJobsRetry runs a dead or waiting job again now, with a fresh set of attempts
+func (cfg *apiConfig) handlerAdminJobsRetry(w http.ResponseWriter, r *http.Request) {
+	job, err := cfg.jobs.Store().RetryJob(r.Context(), r.PathValue("jobID"))
+	if errors.Is(err, jobs.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "job not found or still running")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, cfg.requestActor(r), auditJobRetried, audit.Target{Type: targetJob, ID: job.ID}, nil)
+	responseWithJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/profanity"
+)
+
+type wordListRequest struct {
+	Words []string `json:"words"`
+}
+
+// handlerAdminProfanity shows the profanity mode and the word list of each language
+func (cfg *apiConfig) handlerAdminProfanity(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Mode      string              `json:"mode"`
+		Languages map[string][]string `json:"languages"`
+	}
+	responseWithJSON(w, http.StatusOK, response{
+		Mode:      cfg.profanityMode,
+		Languages: cfg.profanity.Languages(),
+	})
+}
+
+// handlerAdminProfanityCheck previews what the profanity mode would do to a chirp body
+func (cfg *apiConfig) handlerAdminProfanityCheck(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Body string `json:"body"`
+	}
+	type response struct {
+		Mode    string            `json:"mode"`
+		Body    string            `json:"body"`
+		Matches []profanity.Match `json:"matches"`
+	}
+
+	params := request{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	censored, matches := cfg.profanity.Censor(params.Body)
+	if cfg.profanityMode != profanity.ModeReplace {
+		censored = params.Body
+	}
+	responseWithJSON(w, http.StatusOK, response{Mode: cfg.profanityMode, Body: censored, Matches: matches})
+}
+
+// handlerAdminProfanityReplace replaces the word list of a language, an empty list removes it
+func (cfg *apiConfig) handlerAdminProfanityReplace(w http.ResponseWriter, r *http.Request) {
+	params := wordListRequest{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	cfg.updateWordList(w, r, func(words []string) []string {
+		return params.Words
+	})
+}
+
+// handlerAdminProfanityAdd adds words to the list of a language, creating the list if needed
+func (cfg *apiConfig) handlerAdminProfanityAdd(w http.ResponseWriter, r *http.Request) {
+	params := wordListRequest{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || len(params.Words) == 0 {
+		responseWithError(w, http.StatusBadRequest, "words must list at least one word")
+		return
+	}
+	cfg.updateWordList(w, r, func(words []string) []string {
+		return append(words, params.Words...)
+	})
+}
+
+func (cfg *apiConfig) handlerAdminProfanityRemove(w http.ResponseWriter, r *http.Request) {
+	word := strings.ToLower(r.PathValue("word"))
+	cfg.updateWordList(w, r, func(words []string) []string {
+		return slices.DeleteFunc(words, func(listed string) bool {
+			return listed == word
+		})
+	})
+}
+
+// updateWordList applies change to the list of the language in the path and responds with the
+// list it leaves. Chirps are checked against it right away.
+func (cfg *apiConfig) updateWordList(w http.ResponseWriter, r *http.Request, change func(words []string) []string) {
+	language := r.PathValue("language")
+	before, after, err := cfg.profanity.Update(language, change)
+	if errors.Is(err, profanity.ErrInvalidLanguage) {
+		responseWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	changes := audit.Diff(map[string]interface{}{"words": before}, map[string]interface{}{"words": after})
+	if len(changes) > 0 {
+		cfg.auditRequest(r, cfg.requestActor(r), auditWordListChanged, audit.Target{Type: targetWordList, ID: language}, changes)
+	}
+	responseWithJSON(w, http.StatusOK, map[string]interface{}{"language": language, "words": after})
+}
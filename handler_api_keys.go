@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
+)
+
+type APIKeyView struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Key       string     `json:"key,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	LastUsed  *time.Time `json:"last_used_at"`
+}
+
+func apiKeyView(key database.APIKey) APIKeyView {
+	return APIKeyView{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+		ExpiresAt: key.ExpiresAt,
+		LastUsed:  key.LastUsed,
+	}
+}
+
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type KeyRequest struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpireSeconds int      `json:"expires_in_seconds"`
+	}
+
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := KeyRequest{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if params.Name == "" {
+		responseWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		responseWithError(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			responseWithError(w, http.StatusBadRequest, "unknown scope: "+scope)
+			return
+		}
+	}
+
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	key, id, err := auth.GenerateAPIKey()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	dbKey := database.APIKey{
+		ID:     id,
+		UserID: userID,
+		Name:   params.Name,
+		Hash:   auth.HashToken(key),
+		Scopes: params.Scopes,
+	}
+	if params.ExpireSeconds > 0 {
+		exp := time.Now().Add(time.Duration(params.ExpireSeconds) * time.Second)
+		dbKey.ExpiresAt = &exp
+	}
+
+	maxKeys := cfg.limitsFor(dbUser).MaxAPIKeys
+	dbKey, err = cfg.database.CreateAPIKey(dbKey, maxKeys)
+	if errors.Is(err, database.ErrLimitReached) {
+		responseWithError(w, http.StatusForbidden, fmt.Sprintf("your plan allows at most %d active api keys", maxKeys))
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.auditRequest(r, userActor(userID), auditAPIKeyCreated, audit.Target{Type: targetAPIKey, ID: dbKey.ID}, []audit.Change{
+		{Field: "scopes", Before: nil, After: dbKey.Scopes},
+	})
+
+	// The key itself is only ever shown once
+	view := apiKeyView(dbKey)
+	view.Key = key
+	responseWithJSON(w, http.StatusCreated, view)
+}
+
+func (cfg *apiConfig) handlerAPIKeysGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	dbKeys, err := cfg.database.GetUserAPIKeys(userID)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	keys := make([]APIKeyView, len(dbKeys))
+	for i, key := range dbKeys {
+		keys[i] = apiKeyView(key)
+	}
+	responseWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeysDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	keyID := r.PathValue("keyID")
+	err = cfg.database.DeleteAPIKey(userID, keyID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "api key not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditAPIKeyDeleted, audit.Target{Type: targetAPIKey, ID: keyID}, nil)
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
 	database2 "github.com/ethpalser/chirpy/internal/database/v2"
 	"github.com/google/uuid"
 )
 
+var errEmailNotVerified = errors.New("email must be verified first")
+
+// maxChirpRequest bounds how much of a create request is read, well above any chirp a plan allows
+const maxChirpRequest = 64 << 10
+
 type ChirpView struct {
 	ID       int    `json:"id_old"`
 	Body     string `json:"body"`
@@ -30,124 +37,162 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		Body string `json:"body"`
 	}
 
-	accessToken := r.Header.Get("Authorization")
-	if accessToken == "" {
-		responseWithError(w, http.StatusUnauthorized, "invalid auth token")
+	userID, authErr := cfg.authorize(r, auth.ScopeChirpsWrite)
+	if authErr != nil {
+		responseWithError(w, authErrorStatus(authErr), authErr.Error())
 		return
 	}
-	tokenVal := strings.TrimPrefix(accessToken, "Bearer ")
-	jwtToken, jwtErr := auth.ParseJWT(cfg.jwtSecret, tokenVal)
-	if jwtErr != nil {
-		responseWithError(w, http.StatusInternalServerError, jwtErr.Error())
-		return
-	}
-	user, claimErr := jwtToken.Claims.GetSubject()
-	if claimErr != nil {
-		responseWithError(w, http.StatusInternalServerError, claimErr.Error())
+
+	dbUser, userErr := cfg.database.GetUser(userID)
+	if userErr != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
 		return
 	}
-	userID, atoiErr := strconv.Atoi(user)
-	if atoiErr != nil {
-		responseWithError(w, http.StatusInternalServerError, atoiErr.Error())
+	if !dbUser.EmailVerified && !cfg.unverifiedLimits.CanPost {
+		responseWithError(w, http.StatusForbidden, errEmailNotVerified.Error())
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxChirpRequest))
 	params := ChirpRequest{}
 	err := decoder.Decode(&params)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		responseWithError(w, http.StatusRequestEntityTooLarge, "chirp request is too large")
+		return
+	}
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err)
 		responseWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	cleaned, flagged, err := cfg.validateChirp(params.Body, chirpRules(cfg.limitsFor(dbUser)))
 	if err != nil {
 		responseWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	dbChirp, getErr := cfg.database.CreateChirp(cleaned, userID)
+	dbChirp, getErr := cfg.database.CreateChirp(cleaned, userID, chirpEvent(eventChirpCreated))
 	if getErr != nil {
 		responseWithError(w, http.StatusBadRequest, getErr.Error())
 		return
 	}
-	responseWithJSON(w, http.StatusCreated, ChirpView{
+	cfg.outbox.Notify()
+	cfg.flagChirp(r, userActor(userID), strconv.Itoa(dbChirp.ID), flagged)
+	view := ChirpView{
 		ID:       dbChirp.ID,
 		Body:     dbChirp.Message,
 		AuthorID: userID,
-	})
+	}
+	responseWithJSON(w, http.StatusCreated, view)
 }
 
 func (cfg *apiConfig) handlerChirpsCreateV2(w http.ResponseWriter, r *http.Request) {
-	 type ChirpRequest struct {
-               	Body string `json:"body"`
-		UserID  string `json:"user_id"` // temp., as any user could author anyone's chirp
-        }
+	type ChirpRequest struct {
+		Body string `json:"body"`
+	}
+
+	authUserID, authErr := cfg.authorize(r, auth.ScopeChirpsWrite)
+	if authErr != nil {
+		responseWithError(w, authErrorStatus(authErr), authErr.Error())
+		return
+	}
+	authUser, err := cfg.database.GetUser(authUserID)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	if !authUser.EmailVerified && !cfg.unverifiedLimits.CanPost {
+		responseWithError(w, http.StatusForbidden, errEmailNotVerified.Error())
+		return
+	}
 
-	decoder := json.NewDecoder(r.Body)
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxChirpRequest))
 	params := ChirpRequest{}
-	err := decoder.Decode(&params)
+	err = decoder.Decode(&params)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		responseWithError(w, http.StatusRequestEntityTooLarge, "chirp request is too large")
+		return
+	}
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err)
 		responseWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	dbUser, err := cfg.postgresUser(r.Context(), authUser)
+	if errors.Is(err, errEmailNotVerified) {
+		responseWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	if err != nil {
-		responseWithError(w, http.StatusBadRequest, err.Error())
+		responseWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	userID := dbUser.ID
 
-	userID, err := uuid.Parse(params.UserID)
+	cleaned, flagged, err := cfg.validateChirp(params.Body, chirpRules(cfg.limitsForV2(r.Context(), dbUser)))
 	if err != nil {
-		log.Printf("Failed to parse uuid %s string: %s\n", params.UserID, err.Error())
-		responseWithError(w, http.StatusInternalServerError, err.Error())
+		responseWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+
 	args := database2.CreateChirpParams{
 		Body: cleaned,
 		UserID: userID,
 	}
-	dbChirp, err := cfg.dbQueries.CreateChirp(r.Context(), args)
+	dbChirp, err := cfg.createChirpV2(r.Context(), authUserID, args)
 	if err != nil {
 		responseWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	cfg.outbox.Notify()
+	cfg.flagChirp(r, userActor(authUserID), dbChirp.ID.String(), flagged)
 
-	responseWithJSON(w, http.StatusCreated, ChirpView{
+	view := ChirpView{
 		UUID: dbChirp.ID,
 		CreatedAt: dbChirp.CreatedAt,
 		UpdatedAt: dbChirp.UpdatedAt,
 		Body: dbChirp.Body,
 		UserID: dbChirp.UserID,
-	})
+	}
+	responseWithJSON(w, http.StatusCreated, view)
 }
 
-func validateChirp(msg string) (string, error) {
-	if len(msg) > 140 {
-		return "", errors.New("chirp is too long")
+// postgresUser returns the postgres user that authors the chirps of the json db user, linking one
+// the first time or after the linked one was deleted. An existing postgres user with the same email
+// is only linked once that email is verified, as anyone can create a postgres user for any email.
+func (cfg *apiConfig) postgresUser(ctx context.Context, dbUser database.User) (database2.User, error) {
+	if dbUser.PostgresID != "" {
+		id, err := uuid.Parse(dbUser.PostgresID)
+		if err != nil {
+			return database2.User{}, err
+		}
+		pgUser, err := cfg.dbQueries.GetUser(ctx, id)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return pgUser, err
+		}
+		err = cfg.database.UnlinkPostgresUser(dbUser.Id, dbUser.PostgresID)
+		if err != nil {
+			return database2.User{}, err
+		}
 	}
 
-	profanity := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
+	pgUser, err := cfg.dbQueries.GetUserByEmail(ctx, dbUser.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		pgUser, err = cfg.dbQueries.CreateUser(ctx, dbUser.Email)
+	} else if err == nil && !dbUser.EmailVerified {
+		return database2.User{}, errEmailNotVerified
+	}
+	if err != nil {
+		return database2.User{}, err
 	}
-	cleaned := filterProfanity(msg, profanity)
-	return cleaned, nil
-}
 
-func filterProfanity(msg string, badWords map[string]struct{}) string {
-	msg_split := strings.Split(msg, " ")
-	lower := strings.ToLower(msg)
-	lower_split := strings.Split(lower, " ")
-	for index, word := range lower_split {
-		_, ok := badWords[word]
-		if ok {
-			msg_split[index] = "****"
-		}
+	err = cfg.database.LinkPostgresUser(dbUser.Id, pgUser.ID.String())
+	if err != nil {
+		return database2.User{}, err
 	}
-	return strings.Join(msg_split, " ")
+	return pgUser, nil
 }
@@ -3,31 +3,14 @@ package main
 import (
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/ethpalser/chirpy/internal/auth"
 )
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
-	accessToken := r.Header.Get("Authorization")
-	if accessToken == "" {
-		responseWithError(w, http.StatusUnauthorized, "invalid auth token")
-		return
-	}
-	tokenVal := strings.TrimPrefix(accessToken, "Bearer ")
-	jwtToken, jwtErr := auth.ParseJWT(cfg.jwtSecret, tokenVal)
-	if jwtErr != nil {
-		responseWithError(w, http.StatusInternalServerError, jwtErr.Error())
-		return
-	}
-	user, claimErr := jwtToken.Claims.GetSubject()
-	if claimErr != nil {
-		responseWithError(w, http.StatusInternalServerError, claimErr.Error())
-		return
-	}
-	userID, atoiErr := strconv.Atoi(user)
-	if atoiErr != nil {
-		responseWithError(w, http.StatusInternalServerError, atoiErr.Error())
+	userID, authErr := cfg.authorize(r, auth.ScopeChirpsWrite)
+	if authErr != nil {
+		responseWithError(w, authErrorStatus(authErr), authErr.Error())
 		return
 	}
 
@@ -46,11 +29,12 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 	}
 
 	// Delete
-	delErr := cfg.database.DeleteChirp(idVal)
+	delErr := cfg.database.DeleteChirp(idVal, chirpEvent(eventChirpDeleted))
 	if delErr != nil {
 		responseWithError(w, http.StatusNotFound, delErr.Error())
 		return
 	}
+	cfg.outbox.Notify()
 
 	responseWithJSON(w, http.StatusNoContent, nil)
 }
@@ -3,7 +3,6 @@ package main
 import (
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/ethpalser/chirpy/internal/auth"
 	"github.com/ethpalser/chirpy/internal/database"
@@ -11,25 +10,9 @@ import (
 )
 
 func (cfg *apiConfig) handlerChirpsGetOne(w http.ResponseWriter, r *http.Request) {
-	accessToken := r.Header.Get("Authorization")
-	if accessToken == "" {
-		responseWithError(w, http.StatusUnauthorized, "invalid auth token")
-		return
-	}
-	tokenVal := strings.TrimPrefix(accessToken, "Bearer ")
-	jwtToken, jwtErr := auth.ParseJWT(cfg.jwtSecret, tokenVal)
-	if jwtErr != nil {
-		responseWithError(w, http.StatusInternalServerError, jwtErr.Error())
-		return
-	}
-	user, claimErr := jwtToken.Claims.GetSubject()
-	if claimErr != nil {
-		responseWithError(w, http.StatusInternalServerError, claimErr.Error())
-		return
-	}
-	userID, atoiErr := strconv.Atoi(user)
-	if atoiErr != nil {
-		responseWithError(w, http.StatusInternalServerError, atoiErr.Error())
+	userID, authErr := cfg.authorize(r, auth.ScopeChirpsRead)
+	if authErr != nil {
+		responseWithError(w, authErrorStatus(authErr), authErr.Error())
 		return
 	}
 
@@ -58,6 +41,12 @@ func (cfg *apiConfig) handlerChirpsGetOne(w http.ResponseWriter, r *http.Request
 }
 
 func (cfg *apiConfig) handlerChirpsGetOneV2(w http.ResponseWriter, r *http.Request) {
+	authErr := cfg.authorizeAPIKey(r, auth.ScopeChirpsRead)
+	if authErr != nil {
+		responseWithError(w, authErrorStatus(authErr), authErr.Error())
+		return
+	}
+
 	pathChirpID := r.PathValue("chirpID")
 	if pathChirpID == "" {
 		responseWithError(w, http.StatusNotFound, "Chirp not found, missing or invalid id")
@@ -121,6 +110,12 @@ func (cfg *apiConfig) handlerChirpsGetAll(w http.ResponseWriter, r *http.Request
 }
 
 func (cfg *apiConfig) handlerChirpsGetAllV2(w http.ResponseWriter, r *http.Request) {
+	authErr := cfg.authorizeAPIKey(r, auth.ScopeChirpsRead)
+	if authErr != nil {
+		responseWithError(w, authErrorStatus(authErr), authErr.Error())
+		return
+	}
+
 	dbChirps, err := cfg.dbQueries.GetAllChirps(r.Context())
 	if err != nil {
 		responseWithError(w, http.StatusInternalServerError, err.Error())
@@ -2,11 +2,29 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ethpalser/chirpy/internal/audit"
 	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Every failed login gets the same error, whether the email is unknown or the password is wrong
+var errLoginFailed = errors.New("incorrect email or password")
+
+// Ways a user can sign in, recorded with their logins in the audit log
+const (
+	loginPassword  = "password"
+	loginPasskey   = "passkey"
+	loginMagicLink = "magic link"
+	loginOIDC      = "single sign-on"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -27,29 +45,101 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	accountKey := strings.ToLower(strings.TrimSpace(params.Email))
+	ip := clientIP(r)
+	if cfg.rejectLockedLogin(w, accountKey, ip) {
+		return
+	}
+
 	dbUser, getErr := cfg.database.Login(params.Email, params.Password)
+	if errors.Is(getErr, database.ErrUnauthorized) {
+		cfg.recordLoginFailure(accountKey, ip)
+		cfg.auditRequest(r, audit.Actor{Type: audit.ActorAnonymous}, auditLoginFailed, audit.Target{Type: targetEmail, ID: accountKey}, nil)
+		responseWithError(w, http.StatusUnauthorized, errLoginFailed.Error())
+		return
+	}
 	if getErr != nil {
-		responseWithError(w, http.StatusUnauthorized, getErr.Error())
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
 		return
 	}
+	cfg.loginAccountBackoff.Reset(accountKey)
 
-	token, jwtErr := auth.IssueJWT(cfg.jwtSecret, fmt.Sprint(dbUser.Id), params.ExpireSeconds)
-	if jwtErr != nil {
-		responseWithError(w, http.StatusInternalServerError, jwtErr.Error())
+	// The password alone is not enough, a second step must exchange the challenge and a code for tokens
+	if dbUser.TOTPEnabled {
+		cfg.respondWithMFAChallenge(w, dbUser, params.ExpireSeconds, loginPassword)
 		return
 	}
 
-	dbToken, refErr := cfg.database.CreateRefreshToken(dbUser.Id)
+	cfg.respondWithSession(w, r, dbUser, params.ExpireSeconds, loginPassword)
+}
+
+// rejectLockedLogin responds with 429 if the account or ip is locked out after too many failed attempts
+func (cfg *apiConfig) rejectLockedLogin(w http.ResponseWriter, accountKey string, ip string) bool {
+	wait := max(cfg.loginAccountBackoff.Locked(accountKey), cfg.loginIPBackoff.Locked(ip))
+	if wait == 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())+1))
+	responseWithError(w, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+	return true
+}
+
+// recordLoginFailure counts a failed attempt against both the account and the ip, and logs any lockout
+func (cfg *apiConfig) recordLoginFailure(accountKey string, ip string) {
+	if lock := cfg.loginAccountBackoff.Fail(accountKey); lock > 0 {
+		log.Printf("Login locked for account %q for %s after repeated failures, last from %s", accountKey, lock, ip)
+	}
+	if lock := cfg.loginIPBackoff.Fail(ip); lock > 0 {
+		log.Printf("Login locked for ip %s for %s after repeated failures", ip, lock)
+	}
+}
+
+// respondWithSession issues an access token and a new refresh token to a user that has been
+// authenticated, method is how they signed in
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, dbUser database.User, expireSeconds int, method string) {
+	if rejectSuspended(w, dbUser) {
+		return
+	}
+
+	dbToken, refErr := cfg.database.CreateRefreshToken(dbUser.Id, r.UserAgent(), clientIP(r))
 	if refErr != nil {
 		responseWithError(w, http.StatusInternalServerError, refErr.Error())
 		return
 	}
 
+	token, jwtErr := auth.IssueJWT(cfg.jwtSecret, auth.Claims{
+		Role:             dbUser.Role,
+		SessionID:        dbToken.ID,
+		AuthTime:         jwt.NewNumericDate(dbToken.Iss),
+		Version:          dbUser.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: fmt.Sprint(dbUser.Id)},
+	}, expireSeconds)
+	if jwtErr != nil {
+		responseWithError(w, http.StatusInternalServerError, jwtErr.Error())
+		return
+	}
+	cfg.recordAudit(withAuditReason(withAuditActor(r, userActor(dbUser.Id)), "signed in with "+method), auditLogin, userTarget(dbUser.Id), nil)
+
 	responseWithJSON(w, http.StatusOK, UserView{
 		ID:           dbUser.Id,
 		Email:        dbUser.Email,
 		Token:        token,
 		RefreshToken: dbToken.Val,
 		PremiumRed:   dbUser.PremiumRed,
+		Role:         dbUser.Role,
 	})
 }
+
+// rejectSuspended responds with 403 and the reason if the user may not sign in right now
+func rejectSuspended(w http.ResponseWriter, dbUser database.User) bool {
+	suspension := dbUser.Suspension
+	if !suspension.Active(time.Now()) {
+		return false
+	}
+	msg := fmt.Sprintf("account %s: %s", suspension.Kind, suspension.Reason)
+	if suspension.ExpiresAt != nil {
+		msg = fmt.Sprintf("account %s until %s: %s", suspension.Kind, suspension.ExpiresAt.UTC().Format(time.RFC3339), suspension.Reason)
+	}
+	responseWithError(w, http.StatusForbidden, msg)
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/mailer"
+)
+
+const purposeMagicLogin = "magic-login"
+const magicLinkTTL = 15 * time.Minute
+
+func (cfg *apiConfig) handlerLoginMagic(w http.ResponseWriter, r *http.Request) {
+	type MagicRequest struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := MagicRequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(params.Email))
+	if !cfg.magicLinkIPLimiter.Allow(clientIP(r)) || !cfg.magicLinkEmailLimiter.Allow(email) {
+		responseWithError(w, http.StatusTooManyRequests, "too many requests, try again later")
+		return
+	}
+
+	// Respond the same way whether or not the email belongs to a user. The email is sent in the
+	// background, so how long the mailer takes doesn't reveal it either.
+	dbUser, err := cfg.database.GetUserByEmail(params.Email)
+	if err != nil {
+		responseWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+
+	_, err = cfg.jobs.Enqueue(r.Context(), jobMagicLinkEmail, userEmailJob{UserID: dbUser.Id, Email: dbUser.Email})
+	if err != nil {
+		log.Printf("Failed to queue login link for user %d: %s", dbUser.Id, err)
+	}
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// sendMagicLinkEmail is the handler of login link jobs. The link is only signed when it is sent,
+// so it's never stored.
+func (cfg *apiConfig) sendMagicLinkEmail(ctx context.Context, payload json.RawMessage) error {
+	job := userEmailJob{}
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.IssuePurposeToken(cfg.jwtSecret, purposeMagicLogin, fmt.Sprint(job.UserID), magicLinkTTL, map[string]string{
+		"email": job.Email,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/app/login/magic?token=%s", cfg.publicURL, token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      job.Email,
+		Subject: "Your Chirpy login link",
+		Body:    fmt.Sprintf("Open the link below within 15 minutes to log in to Chirpy:\n%s\n\nThe link works once. If you didn't ask for it, you can ignore this email.\n", link),
+	})
+}
+
+func (cfg *apiConfig) handlerLoginMagicVerify(w http.ResponseWriter, r *http.Request) {
+	type VerifyRequest struct {
+		Token         string `json:"token"`
+		ExpireSeconds int    `json:"expires_in_seconds"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := VerifyRequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	claims, err := auth.ParsePurposeToken(cfg.jwtSecret, purposeMagicLogin, params.Token)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired login link")
+		return
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired login link")
+		return
+	}
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil || dbUser.Email != claims.Data["email"] {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired login link")
+		return
+	}
+
+	// A signed link used twice was intercepted or shared
+	err = cfg.database.ConsumeTokenID(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		cfg.auditRequest(r, audit.Actor{Type: audit.ActorAnonymous}, auditLoginFailed, userTarget(userID), nil)
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired login link")
+		return
+	}
+
+	// Receiving the link proves the user owns the email
+	if !dbUser.EmailVerified {
+		err = cfg.database.VerifyUserEmail(dbUser.Id, dbUser.Email)
+		if err != nil {
+			log.Printf("Failed to mark email verified for user %d: %s", dbUser.Id, err)
+		}
+	}
+
+	if dbUser.TOTPEnabled {
+		cfg.respondWithMFAChallenge(w, dbUser, params.ExpireSeconds, loginMagicLink)
+		return
+	}
+	cfg.respondWithSession(w, r, dbUser, params.ExpireSeconds, loginMagicLink)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
+)
+
+const purposeMFA = "mfa"
+const mfaChallengeTTL = 5 * time.Minute
+const recoveryCodeCount = 10
+
+type MFAChallengeView struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, dbUser database.User, expireSeconds int, method string) {
+	if rejectSuspended(w, dbUser) {
+		return
+	}
+
+	token, err := auth.IssuePurposeToken(cfg.jwtSecret, purposeMFA, fmt.Sprint(dbUser.Id), mfaChallengeTTL, map[string]string{
+		"expires_in_seconds": fmt.Sprint(expireSeconds),
+		"method":             method,
+	})
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	responseWithJSON(w, http.StatusOK, MFAChallengeView{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type EnrollView struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	err = cfg.database.SetTOTPSecret(userID, secret)
+	if errors.Is(err, database.ErrConflict) {
+		responseWithError(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responseWithJSON(w, http.StatusOK, EnrollView{
+		Secret: secret,
+		URI:    auth.TOTPURI(secret, "Chirpy", dbUser.Email),
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type ConfirmRequest struct {
+		Code string `json:"code"`
+	}
+	type ConfirmView struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := ConfirmRequest{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	if dbUser.TOTPEnabled || dbUser.TOTPSecret == "" {
+		responseWithError(w, http.StatusConflict, "no two-factor enrollment to confirm")
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(dbUser.TOTPSecret, params.Code, time.Now())
+	if !ok {
+		responseWithError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	hashed := make([]string, len(codes))
+	for i, code := range codes {
+		hashed[i] = auth.HashRecoveryCode(code)
+	}
+
+	err = cfg.database.EnableTOTP(userID, step, hashed)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditMFAEnabled, userTarget(userID), []audit.Change{
+		{Field: "totp_enabled", Before: false, After: true},
+	})
+
+	// Recovery codes are only ever shown once
+	responseWithJSON(w, http.StatusOK, ConfirmView{
+		RecoveryCodes: codes,
+	})
+}
+
+// handlerLoginMFA exchanges the challenge from handlerLogin and a TOTP or recovery code for tokens
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type MFARequest struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := MFARequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	claims, err := auth.ParsePurposeToken(cfg.jwtSecret, purposeMFA, params.MFAToken)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil || !dbUser.TOTPEnabled {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+
+	// Codes are short enough to guess, so failures count towards the same lockout as passwords
+	accountKey := strings.ToLower(dbUser.Email)
+	ip := clientIP(r)
+	if cfg.rejectLockedLogin(w, accountKey, ip) {
+		return
+	}
+
+	// The challenge is used up before any code is, so a replayed or expired challenge can't burn a
+	// recovery code and each challenge allows one guess
+	err = cfg.database.ConsumeTokenID(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired mfa token")
+		return
+	}
+
+	if params.RecoveryCode != "" {
+		err = cfg.database.UseRecoveryCode(userID, auth.HashRecoveryCode(params.RecoveryCode))
+	} else {
+		step, ok := auth.ValidateTOTP(dbUser.TOTPSecret, params.Code, time.Now())
+		if !ok {
+			cfg.recordLoginFailure(accountKey, ip)
+			cfg.auditRequest(r, audit.Actor{Type: audit.ActorAnonymous}, auditLoginFailed, userTarget(userID), nil)
+			responseWithError(w, http.StatusUnauthorized, "invalid code")
+			return
+		}
+		err = cfg.database.UseTOTPStep(userID, step)
+	}
+	if err != nil {
+		cfg.recordLoginFailure(accountKey, ip)
+		cfg.auditRequest(r, audit.Actor{Type: audit.ActorAnonymous}, auditLoginFailed, userTarget(userID), nil)
+		responseWithError(w, http.StatusUnauthorized, "invalid code")
+		return
+	}
+	cfg.loginAccountBackoff.Reset(accountKey)
+
+	expireSeconds, _ := strconv.Atoi(claims.Data["expires_in_seconds"])
+	cfg.respondWithSession(w, r, dbUser, expireSeconds, claims.Data["method"]+" and a second factor")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/ethpalser/chirpy/internal/oidc"
+)
+
+const purposeOIDCLogin = "oidc-login"
+const oidcLoginTTL = 10 * time.Minute
+const oidcCookie = "chirpy_oidc"
+
+// handlerOIDCLogin redirects to the identity provider, keeping the state, nonce and PKCE verifier
+// in a signed cookie to check in the callback
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		responseWithError(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	session, err := auth.IssuePurposeToken(cfg.jwtSecret, purposeOIDCLogin, "", oidcLoginTTL, map[string]string{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+	})
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	authURL, err := cfg.oidcProvider.AuthCodeURL(r.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		responseWithError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    session,
+		Path:     "/api/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		responseWithError(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "missing or expired login session")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/api/oidc", MaxAge: -1})
+
+	claims, err := auth.ParsePurposeToken(cfg.jwtSecret, purposeOIDCLogin, cookie.Value)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "missing or expired login session")
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		responseWithError(w, http.StatusUnauthorized, "identity provider returned: "+providerErr)
+		return
+	}
+	state := query.Get("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(claims.Data["state"])) != 1 {
+		responseWithError(w, http.StatusBadRequest, "state does not match")
+		return
+	}
+	err = cfg.database.ConsumeTokenID(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "missing or expired login session")
+		return
+	}
+
+	rawIDToken, err := cfg.oidcProvider.Exchange(r.Context(), query.Get("code"), claims.Data["verifier"])
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	idToken, err := cfg.oidcProvider.VerifyIDToken(r.Context(), rawIDToken, claims.Data["nonce"])
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// Only a verified email may link to an existing account, or anyone could claim it at the provider
+	if idToken.Email == "" || !idToken.EmailVerified {
+		cfg.auditRequest(r, audit.Actor{Type: audit.ActorAnonymous}, auditLoginFailed, audit.Target{Type: targetEmail, ID: strings.ToLower(idToken.Email)}, nil)
+		responseWithError(w, http.StatusForbidden, "identity provider did not verify the email")
+		return
+	}
+
+	dbUser, err := cfg.findOrCreateSSOUser(r, idToken.Email)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if dbUser.TOTPEnabled {
+		cfg.respondWithMFAChallenge(w, dbUser, 0, loginOIDC)
+		return
+	}
+	cfg.respondWithSession(w, r, dbUser, 0, loginOIDC)
+}
+
+// findOrCreateSSOUser links to the user with the email, or creates one with a random password they can
+// reset later. An existing account whose email was never verified may have been registered by someone
+// else to wait for the owner, so it is reset before it is linked.
+func (cfg *apiConfig) findOrCreateSSOUser(r *http.Request, email string) (database.User, error) {
+	password, err := oidc.RandomString()
+	if err != nil {
+		return database.User{}, err
+	}
+
+	dbUser, err := cfg.database.GetUserByEmailFold(email)
+	if errors.Is(err, database.ErrNotExist) {
+		dbUser, err = cfg.database.CreateUser(email, password)
+		if err != nil {
+			return database.User{}, err
+		}
+		err = cfg.database.VerifyUserEmail(dbUser.Id, dbUser.Email)
+		if err != nil {
+			return database.User{}, err
+		}
+		dbUser.EmailVerified = true
+		return dbUser, nil
+	}
+	if err != nil || dbUser.EmailVerified {
+		return dbUser, err
+	}
+
+	dbUser, err = cfg.database.ClaimUnverifiedUser(dbUser.Id, dbUser.Email, password)
+	if err != nil {
+		return database.User{}, err
+	}
+	changes := audit.Diff(map[string]interface{}{"email_verified": false}, map[string]interface{}{"email_verified": true})
+	cfg.auditRequest(r, userActor(dbUser.Id), auditUserUpdated, userTarget(dbUser.Id), append(changes, audit.Secret("password")))
+	return dbUser, nil
+}
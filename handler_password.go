@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/mailer"
+)
+
+const purposePasswordReset = "password-reset"
+const passwordResetTTL = time.Hour
+
+type userEmailJob struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type ForgotRequest struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := ForgotRequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	// Respond the same way whether or not the email belongs to a user. The email is sent in the
+	// background, so how long the mailer takes doesn't reveal it either.
+	dbUser, err := cfg.database.GetUserByEmail(params.Email)
+	if err != nil {
+		responseWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+
+	_, err = cfg.jobs.Enqueue(r.Context(), jobPasswordResetEmail, userEmailJob{UserID: dbUser.Id, Email: dbUser.Email})
+	if err != nil {
+		log.Printf("Failed to queue password reset email for user %d: %s", dbUser.Id, err)
+	}
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// sendPasswordResetEmail is the handler of password reset email jobs. The link is only signed
+// when it is sent, so it's never stored.
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, payload json.RawMessage) error {
+	job := userEmailJob{}
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.IssuePurposeToken(cfg.jwtSecret, purposePasswordReset, fmt.Sprint(job.UserID), passwordResetTTL, nil)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/app/reset-password?token=%s", cfg.publicURL, token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      job.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    fmt.Sprintf("A password reset was requested for your Chirpy account.\n\nOpen the link below within an hour to choose a new password:\n%s\n\nIf this wasn't you, you can ignore this email.\n", link),
+	})
+}
+
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type ResetRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := ResetRequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	policyErr := cfg.passwordPolicy.Validate(params.Password)
+	if policyErr != nil {
+		responseWithError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	}
+
+	claims, err := auth.ParsePurposeToken(cfg.jwtSecret, purposePasswordReset, params.Token)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+
+	err = cfg.database.ConsumeTokenID(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+
+	err = cfg.database.UpdateUserPassword(userID, params.Password)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+
+	// Whoever had access to the account before the reset should lose it
+	_, err = cfg.database.RevokeAllRefreshTokens(userID)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditPasswordReset, userTarget(userID), []audit.Change{audit.Secret("password")})
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/database"
+)
+
+type SessionView struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (cfg *apiConfig) handlerSessionsGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	dbSessions, err := cfg.database.GetSessions(userID)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sessions := make([]SessionView, len(dbSessions))
+	for i, s := range dbSessions {
+		sessions[i] = SessionView{
+			ID:        s.ID,
+			UserAgent: s.UserAgent,
+			IP:        s.IP,
+			CreatedAt: s.Iss,
+			LastUsed:  s.LastUsed,
+			ExpiresAt: s.Exp,
+		}
+	}
+	responseWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionsRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	sessionID := r.PathValue("sessionID")
+	err = cfg.database.RevokeSession(userID, sessionID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditSessionRevoked, audit.Target{Type: targetSession, ID: sessionID}, nil)
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerSessionsRevokeAll logs the user out everywhere by revoking all of their refresh tokens
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	_, err = cfg.database.RevokeAllRefreshTokens(userID)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditSessionsRevoked, userTarget(userID), nil)
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/ethpalser/chirpy/internal/auth"
 	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type TokenView struct {
@@ -30,7 +31,29 @@ func (cfg *apiConfig) handlerTokenRefresh(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	accessToken, jwtErr := auth.IssueJWT(cfg.jwtSecret, fmt.Sprint(dbToken.UserID), 3600)
+	touchErr := cfg.database.TouchRefreshToken(tokenVal, clientIP(r))
+	if touchErr != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	// The role is read again so a changed role takes effect on the next refresh
+	dbUser, userErr := cfg.database.GetUser(dbToken.UserID)
+	if userErr != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	if rejectSuspended(w, dbUser) {
+		return
+	}
+
+	accessToken, jwtErr := auth.IssueJWT(cfg.jwtSecret, auth.Claims{
+		Role:             dbUser.Role,
+		SessionID:        dbToken.ID,
+		AuthTime:         jwt.NewNumericDate(dbToken.Iss),
+		Version:          dbUser.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: fmt.Sprint(dbToken.UserID)},
+	}, 3600)
 	if jwtErr != nil {
 		responseWithError(w, http.StatusInternalServerError, "something went wrong")
 		return
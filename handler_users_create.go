@@ -2,9 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/ethpalser/chirpy/internal/mailer"
 	"github.com/google/uuid"
 )
 
@@ -15,6 +20,7 @@ type UserView struct {
 	Token        string `json:"token,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 	PremiumRed   bool   `json:"is_chirpy_red"`
+	Role         string `json:"role,omitempty"`
 	CreatedAt	time.Time	`json:"created_at"`
 	UpdatedAt	time.Time	`json:"updated_at"`
 }
@@ -33,17 +39,34 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	policyErr := cfg.passwordPolicy.Validate(params.Password)
+	if policyErr != nil {
+		responseWithError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	}
+
 	dbUser, getErr := cfg.database.CreateUser(params.Email, params.Password)
+	if errors.Is(getErr, database.ErrInvalidEmail) {
+		responseWithError(w, http.StatusBadRequest, getErr.Error())
+		return
+	}
 	if getErr != nil {
 		responseWithError(w, http.StatusInternalServerError, getErr.Error())
 		return
 	}
 
-	responseWithJSON(w, http.StatusCreated, UserView{
+	mailErr := cfg.queueVerificationEmail(r.Context(), fmt.Sprint(dbUser.Id), dbUser.Email)
+	if mailErr != nil {
+		log.Printf("Failed to queue verification email to user %d: %s", dbUser.Id, mailErr)
+	}
+
+	view := UserView{
 		ID:         dbUser.Id,
 		Email:      dbUser.Email,
 		PremiumRed: dbUser.PremiumRed,
-	})
+	}
+	cfg.emitEvent(eventUserCreated, fmt.Sprint(dbUser.Id), view)
+	responseWithJSON(w, http.StatusCreated, view)
 }
 
 func (cfg *apiConfig) handlerUsersCreateV2(w http.ResponseWriter, r *http.Request) {
@@ -59,16 +82,29 @@ func (cfg *apiConfig) handlerUsersCreateV2(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if mailer.ValidateAddress(params.Email) != nil {
+		responseWithError(w, http.StatusBadRequest, mailer.ErrInvalidAddress.Error())
+		return
+	}
+
 	dbUser, createErr := cfg.dbQueries.CreateUser(r.Context(), params.Email)
 	if createErr != nil {
 		responseWithError(w, http.StatusInternalServerError, createErr.Error())
 		return
 	}
 
-	responseWithJSON(w, http.StatusCreated, UserView{
+	mailErr := cfg.queueVerificationEmail(r.Context(), dbUser.ID.String(), dbUser.Email)
+	if mailErr != nil {
+		log.Printf("Failed to queue verification email to user %s: %s", dbUser.ID, mailErr)
+	}
+
+	view := UserView{
 		UUID:		dbUser.ID,
 		CreatedAt:	dbUser.CreatedAt,
 		UpdatedAt:	dbUser.UpdatedAt,
 		Email:		dbUser.Email,
-	})
+		PremiumRed:	dbUser.IsChirpyRed,
+	}
+	cfg.emitEvent(eventUserCreated, dbUser.ID.String(), view)
+	responseWithJSON(w, http.StatusCreated, view)
 }
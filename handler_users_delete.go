@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerUsersDelete schedules the caller's account for deletion after the grace period.
+// It needs an access token from a recent login, not just a refresh.
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type DeleteView struct {
+		DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	}
+
+	claims, err := cfg.getAuthClaims(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > cfg.reauthWindow {
+		responseWithError(w, http.StatusUnauthorized, "log in again to delete your account")
+		return
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+
+	at := time.Now().Add(cfg.deletionGrace)
+	err = cfg.database.ScheduleUserDeletion(userID, at)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditUserDeleted, userTarget(userID), []audit.Change{
+		{Field: "deletion_scheduled_at", Before: nil, After: at},
+	})
+
+	responseWithJSON(w, http.StatusAccepted, DeleteView{
+		DeletionScheduledAt: at,
+	})
+}
+
+// handlerUsersRestore cancels a scheduled deletion during the grace period
+func (cfg *apiConfig) handlerUsersRestore(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	err = cfg.database.CancelUserDeletion(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "account is not scheduled for deletion")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditUserRestored, userTarget(userID), nil)
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// purgeDeletedAccounts deletes the accounts whose grace period is over from both backends
+func (cfg *apiConfig) purgeDeletedAccounts(ctx context.Context) {
+	users, err := cfg.database.GetUsersDueForDeletion(time.Now())
+	if err != nil {
+		log.Printf("Account deletion failed to list users: %s", err)
+		return
+	}
+
+	for _, user := range users {
+		if export, exportErr := cfg.database.GetExport(user.Id); exportErr == nil && export.Path != "" {
+			if rmErr := os.Remove(export.Path); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				log.Printf("Account deletion failed to remove export for user %d: %s", user.Id, rmErr)
+			}
+		}
+
+		// Only the postgres user linked to the account is deleted, its chirps cascade
+		if user.PostgresID != "" {
+			pgID, err := uuid.Parse(user.PostgresID)
+			if err != nil {
+				log.Printf("Account deletion failed on postgres for user %d: %s", user.Id, err)
+				continue
+			}
+			_, err = cfg.dbQueries.DeleteUser(ctx, pgID)
+			if err != nil {
+				log.Printf("Account deletion failed on postgres for user %d: %s", user.Id, err)
+				continue
+			}
+		}
+		err = cfg.database.DeleteUser(user.Id)
+		if err != nil {
+			log.Printf("Account deletion failed on json db for user %d: %s", user.Id, err)
+			continue
+		}
+		cfg.recordAudit(ctx, auditUserPurged, userTarget(user.Id), nil)
+		log.Printf("Deleted account of user %d", user.Id)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// exportMaxAge is how long a finished export is served before a new one is assembled
+const exportMaxAge = 24 * time.Hour
+
+type ExportView struct {
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// handlerUsersExport downloads the caller's data export once it's ready. The first request
+// queues the export and responds 202, later requests report its status until it can be downloaded.
+func (cfg *apiConfig) handlerUsersExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	export, err := cfg.database.GetExport(userID)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err == nil && export.Status == database.ExportPending {
+		responseWithJSON(w, http.StatusAccepted, exportView(export))
+		return
+	}
+	if err == nil && export.Status == database.ExportReady && time.Since(*export.CompletedAt) < exportMaxAge {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chirpy-export-%d.zip\"", userID))
+		http.ServeFile(w, r, export.Path)
+		return
+	}
+
+	export = database.Export{
+		UserID:      userID,
+		Status:      database.ExportPending,
+		RequestedAt: time.Now(),
+	}
+	err = cfg.database.SaveExport(export)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_, err = cfg.jobs.Enqueue(r.Context(), jobUserExport, exportJob{UserID: userID})
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	responseWithJSON(w, http.StatusAccepted, exportView(export))
+}
+
+func exportView(export database.Export) ExportView {
+	return ExportView{
+		Status:      export.Status,
+		RequestedAt: export.RequestedAt,
+		CompletedAt: export.CompletedAt,
+		Error:       export.Error,
+	}
+}
+
+type exportJob struct {
+	UserID int `json:"user_id"`
+}
+
+// buildExport is the handler of export jobs. A failed export is retried by the queue, and marked
+// failed meanwhile so the user can see it and request another.
+func (cfg *apiConfig) buildExport(ctx context.Context, payload json.RawMessage) error {
+	job := exportJob{}
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return err
+	}
+	export, err := cfg.database.GetExport(job.UserID)
+	if err != nil {
+		return fmt.Errorf("export for user %d not found: %w", job.UserID, err)
+	}
+
+	path, buildErr := cfg.writeExportArchive(ctx, job.UserID)
+	now := time.Now()
+	export.CompletedAt = &now
+	if buildErr != nil {
+		export.Status = database.ExportFailed
+		export.Error = "export failed, request it again"
+	} else {
+		export.Status = database.ExportReady
+		export.Path = path
+		export.Error = ""
+	}
+
+	err = cfg.database.SaveExport(export)
+	if err != nil {
+		return fmt.Errorf("failed to save export for user %d: %w", job.UserID, err)
+	}
+	return buildErr
+}
+
+// writeExportArchive writes a zip of user.json and chirps.json to the export directory
+func (cfg *apiConfig) writeExportArchive(ctx context.Context, userID int) (string, error) {
+	type ExportChirp struct {
+		ID        string    `json:"id"`
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"created_at,omitempty"`
+	}
+	type ExportUser struct {
+		ID            int           `json:"id"`
+		Email         string        `json:"email"`
+		EmailVerified bool          `json:"is_email_verified"`
+		PremiumRed    bool          `json:"is_chirpy_red"`
+		Role          string        `json:"role"`
+		TOTPEnabled   bool          `json:"totp_enabled"`
+		Sessions      []SessionView `json:"sessions"`
+		ExportedAt    time.Time     `json:"exported_at"`
+	}
+
+	dbUser, dbChirps, dbTokens, err := cfg.database.GetUserData(userID)
+	if err != nil {
+		return "", err
+	}
+
+	user := ExportUser{
+		ID:            dbUser.Id,
+		Email:         dbUser.Email,
+		EmailVerified: dbUser.EmailVerified,
+		PremiumRed:    dbUser.PremiumRed,
+		Role:          dbUser.Role,
+		TOTPEnabled:   dbUser.TOTPEnabled,
+		Sessions:      []SessionView{},
+		ExportedAt:    time.Now(),
+	}
+	for _, token := range dbTokens {
+		user.Sessions = append(user.Sessions, SessionView{
+			ID:        token.ID,
+			UserAgent: token.UserAgent,
+			IP:        token.IP,
+			CreatedAt: token.Iss,
+			LastUsed:  token.LastUsed,
+			ExpiresAt: token.Exp,
+		})
+	}
+
+	chirps := []ExportChirp{}
+	for _, chirp := range dbChirps {
+		chirps = append(chirps, ExportChirp{ID: fmt.Sprint(chirp.ID), Body: chirp.Message})
+	}
+	// Chirps in postgres belong to the postgres user linked to the account
+	if dbUser.PostgresID != "" {
+		pgID, err := uuid.Parse(dbUser.PostgresID)
+		if err != nil {
+			return "", err
+		}
+		pgChirps, err := cfg.dbQueries.GetChirpsByUser(ctx, pgID)
+		if err != nil {
+			return "", err
+		}
+		for _, chirp := range pgChirps {
+			chirps = append(chirps, ExportChirp{ID: chirp.ID.String(), Body: chirp.Body, CreatedAt: chirp.CreatedAt})
+		}
+	}
+
+	err = os.MkdirAll(cfg.exportDir, 0700)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(cfg.exportDir, fmt.Sprintf("user-%d.zip", userID))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for name, content := range map[string]interface{}{"user.json": user, "chirps.json": chirps} {
+		entry, err := archive.Create(name)
+		if err != nil {
+			return "", err
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(content)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		return "", err
+	}
+	return path, file.Close()
+}
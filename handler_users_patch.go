@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// handlerUsersPatch changes only the fields sent, and also serves the older PUT /api/users. Changing the
+// email or password needs the current password.
+func (cfg *apiConfig) handlerUsersPatch(w http.ResponseWriter, r *http.Request) {
+	type PatchRequest struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	userID, authErr := cfg.authorize(r, auth.ScopeProfileWrite)
+	if authErr != nil {
+		responseWithError(w, authErrorStatus(authErr), authErr.Error())
+		return
+	}
+	// nil when an api key made the change
+	claims, _ := cfg.getAuthClaims(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := PatchRequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+
+	if params.Email != nil || params.Password != nil {
+		accountKey := strings.ToLower(dbUser.Email)
+		ip := clientIP(r)
+		if cfg.rejectLockedLogin(w, accountKey, ip) {
+			return
+		}
+		err = cfg.database.CheckPassword(userID, params.CurrentPassword)
+		if errors.Is(err, database.ErrUnauthorized) {
+			cfg.recordLoginFailure(accountKey, ip)
+			responseWithError(w, http.StatusForbidden, "current password is incorrect")
+			return
+		}
+		if err != nil {
+			responseWithError(w, http.StatusInternalServerError, "something went wrong")
+			return
+		}
+	}
+	if params.Password != nil {
+		policyErr := cfg.passwordPolicy.Validate(*params.Password)
+		if policyErr != nil {
+			responseWithError(w, http.StatusBadRequest, policyErr.Error())
+			return
+		}
+	}
+
+	updated, err := cfg.database.PatchUser(userID, database.UserPatch{
+		Email:    params.Email,
+		Password: params.Password,
+	})
+	if errors.Is(err, database.ErrInvalidEmail) {
+		responseWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrConflict) {
+		responseWithError(w, http.StatusConflict, "email is already in use")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	changes := audit.Diff(map[string]interface{}{"email": dbUser.Email}, map[string]interface{}{"email": updated.Email})
+	if params.Password != nil {
+		changes = append(changes, audit.Secret("password"))
+	}
+	if len(changes) > 0 {
+		cfg.auditRequest(r, userActor(userID), auditUserUpdated, userTarget(userID), changes)
+	}
+
+	if updated.Email != dbUser.Email {
+		mailErr := cfg.queueVerificationEmail(r.Context(), fmt.Sprint(userID), updated.Email)
+		if mailErr != nil {
+			log.Printf("Failed to queue verification email to user %d: %s", userID, mailErr)
+		}
+	}
+
+	// Sign out everywhere else, keeping the session making the change if it used an access token.
+	// Changing the password bumped the token version, so that session gets a new access token.
+	token := ""
+	if params.Password != nil {
+		keep := ""
+		if claims != nil {
+			keep = claims.SessionID
+			token, err = auth.IssueJWT(cfg.jwtSecret, auth.Claims{
+				Role:             updated.Role,
+				SessionID:        claims.SessionID,
+				AuthTime:         claims.AuthTime,
+				Version:          updated.TokenVersion,
+				RegisteredClaims: jwt.RegisteredClaims{Subject: fmt.Sprint(userID)},
+			}, 3600)
+			if err != nil {
+				responseWithError(w, http.StatusInternalServerError, "something went wrong")
+				return
+			}
+		}
+		_, err = cfg.database.RevokeOtherRefreshTokens(userID, keep)
+		if err != nil {
+			responseWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	responseWithJSON(w, http.StatusOK, UserView{
+		ID:         updated.Id,
+		Email:      updated.Email,
+		Token:      token,
+		PremiumRed: updated.PremiumRed,
+		Role:       updated.Role,
+	})
+}
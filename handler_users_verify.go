@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/auth"
+	database2 "github.com/ethpalser/chirpy/internal/database/v2"
+	"github.com/ethpalser/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const purposeEmailVerify = "email-verify"
+const emailVerifyTTL = 24 * time.Hour
+
+type verificationEmailJob struct {
+	Subject string `json:"subject"`
+	Email   string `json:"email"`
+}
+
+// queueVerificationEmail sends the verification email in the background, retrying if the mailer fails.
+// The subject is the user's id, an int for the json db or a uuid for postgres.
+func (cfg *apiConfig) queueVerificationEmail(ctx context.Context, subject string, email string) error {
+	_, err := cfg.jobs.Enqueue(ctx, jobVerificationEmail, verificationEmailJob{Subject: subject, Email: email})
+	return err
+}
+
+// sendVerificationEmail is the handler of verification email jobs. It mails a signed link for the
+// user to confirm they own the email, the link is only signed when it is sent so it's never stored.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, payload json.RawMessage) error {
+	job := verificationEmailJob{}
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.IssuePurposeToken(cfg.jwtSecret, purposeEmailVerify, job.Subject, emailVerifyTTL, map[string]string{
+		"email": job.Email,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/app/verify?token=%s", cfg.publicURL, token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      job.Email,
+		Subject: "Verify your Chirpy email",
+		Body:    fmt.Sprintf("Welcome to Chirpy!\n\nConfirm your email by opening the link below within 24 hours:\n%s\n", link),
+	})
+}
+
+func (cfg *apiConfig) handlerUsersVerify(w http.ResponseWriter, r *http.Request) {
+	type VerifyRequest struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := VerifyRequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	claims, err := auth.ParsePurposeToken(cfg.jwtSecret, purposeEmailVerify, params.Token)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired verification token")
+		return
+	}
+	email := claims.Data["email"]
+
+	// Postgres users are identified by uuid, json db users by int
+	if userUUID, uuidErr := uuid.Parse(claims.Subject); uuidErr == nil {
+		rows, err := cfg.dbQueries.SetUserEmailVerified(r.Context(), database2.SetUserEmailVerifiedParams{
+			ID:    userUUID,
+			Email: email,
+		})
+		if err != nil {
+			responseWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if rows == 0 {
+			responseWithError(w, http.StatusBadRequest, "invalid or expired verification token")
+			return
+		}
+		responseWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired verification token")
+		return
+	}
+	err = cfg.database.VerifyUserEmail(userID, email)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired verification token")
+		return
+	}
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
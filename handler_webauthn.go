@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/ethpalser/chirpy/internal/webauthn"
+)
+
+const purposeWebAuthnRegister = "webauthn-register"
+const purposeWebAuthnLogin = "webauthn-login"
+const webAuthnCeremonyTTL = 5 * time.Minute
+
+// issueCeremonySession signs the challenge so the server doesn't have to store it between the two requests
+func (cfg *apiConfig) issueCeremonySession(purpose string, subject string, challenge []byte) (string, error) {
+	return auth.IssuePurposeToken(cfg.jwtSecret, purpose, subject, webAuthnCeremonyTTL, map[string]string{
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+	})
+}
+
+// consumeCeremonySession checks the session from the first request and returns its claims and challenge.
+// Each session can only complete one ceremony.
+func (cfg *apiConfig) consumeCeremonySession(purpose string, session string) (*auth.PurposeClaims, []byte, error) {
+	claims, err := auth.ParsePurposeToken(cfg.jwtSecret, purpose, session)
+	if err != nil {
+		return nil, nil, err
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(claims.Data["challenge"])
+	if err != nil {
+		return nil, nil, err
+	}
+	err = cfg.database.ConsumeTokenID(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, nil, err
+	}
+	return claims, challenge, nil
+}
+
+func (cfg *apiConfig) handlerWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	type BeginView struct {
+		Session string                   `json:"session"`
+		Options webauthn.CreationOptions `json:"publicKey"`
+	}
+
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	passkeys, err := cfg.database.GetUserPasskeys(userID)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Stop the user registering the same authenticator twice
+	exclude := [][]byte{}
+	for _, passkey := range passkeys {
+		id, err := base64.RawURLEncoding.DecodeString(passkey.ID)
+		if err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	session, err := cfg.issueCeremonySession(purposeWebAuthnRegister, fmt.Sprint(userID), challenge)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	responseWithJSON(w, http.StatusOK, BeginView{
+		Session: session,
+		Options: cfg.relyingParty.CreationOptions(challenge, []byte(fmt.Sprint(userID)), dbUser.Email, exclude),
+	})
+}
+
+func (cfg *apiConfig) handlerWebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	type FinishRequest struct {
+		Session    string                       `json:"session"`
+		Name       string                       `json:"name"`
+		Credential webauthn.AttestationResponse `json:"credential"`
+	}
+	type PasskeyView struct {
+		ID        string    `json:"id"`
+		Name      string    `json:"name"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := FinishRequest{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	claims, challenge, err := cfg.consumeCeremonySession(purposeWebAuthnRegister, params.Session)
+	if err != nil || claims.Subject != fmt.Sprint(userID) {
+		responseWithError(w, http.StatusBadRequest, "invalid or expired session")
+		return
+	}
+
+	cred, err := cfg.relyingParty.VerifyRegistration(challenge, params.Credential)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passkey, err := cfg.database.CreatePasskey(database.Passkey{
+		ID:        base64.RawURLEncoding.EncodeToString(cred.ID),
+		UserID:    userID,
+		Name:      params.Name,
+		PublicKey: cred.PublicKey,
+		SignCount: cred.SignCount,
+	})
+	if errors.Is(err, database.ErrConflict) {
+		responseWithError(w, http.StatusConflict, "passkey is already registered")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responseWithJSON(w, http.StatusCreated, PasskeyView{
+		ID:        passkey.ID,
+		Name:      passkey.Name,
+		CreatedAt: passkey.CreatedAt,
+	})
+}
+
+func (cfg *apiConfig) handlerWebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	type BeginView struct {
+		Session string                  `json:"session"`
+		Options webauthn.RequestOptions `json:"publicKey"`
+	}
+
+	// No credentials are listed, the browser offers any discoverable passkey for this site. Listing a
+	// user's credentials would need an email, and the response would show whether it has an account.
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	session, err := cfg.issueCeremonySession(purposeWebAuthnLogin, "", challenge)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	responseWithJSON(w, http.StatusOK, BeginView{
+		Session: session,
+		Options: cfg.relyingParty.RequestOptions(challenge, nil),
+	})
+}
+
+func (cfg *apiConfig) handlerWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	type FinishRequest struct {
+		Session       string                     `json:"session"`
+		Credential    webauthn.AssertionResponse `json:"credential"`
+		ExpireSeconds int                        `json:"expires_in_seconds"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := FinishRequest{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	_, challenge, err := cfg.consumeCeremonySession(purposeWebAuthnLogin, params.Session)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "invalid or expired session")
+		return
+	}
+
+	credID, err := params.Credential.CredentialID()
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	passkey, err := cfg.database.GetPasskey(base64.RawURLEncoding.EncodeToString(credID))
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	handle, err := params.Credential.UserHandle()
+	if err != nil || (len(handle) > 0 && string(handle) != strconv.Itoa(passkey.UserID)) {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+
+	signCount, err := cfg.relyingParty.VerifyAssertion(challenge, webauthn.Credential{
+		ID:        credID,
+		PublicKey: passkey.PublicKey,
+		SignCount: passkey.SignCount,
+	}, params.Credential)
+	if err != nil {
+		cfg.auditRequest(r, audit.Actor{Type: audit.ActorAnonymous}, auditLoginFailed, userTarget(passkey.UserID), nil)
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// A sign count that went backwards means the authenticator may have been cloned
+	err = cfg.database.UsePasskey(passkey.ID, signCount)
+	if err != nil {
+		cfg.auditRequest(r, audit.Actor{Type: audit.ActorAnonymous}, auditLoginFailed, userTarget(passkey.UserID), nil)
+		responseWithError(w, http.StatusUnauthorized, webauthn.ErrSignCount.Error())
+		return
+	}
+
+	dbUser, err := cfg.database.GetUser(passkey.UserID)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+		return
+	}
+	cfg.respondWithSession(w, r, dbUser, params.ExpireSeconds, loginPasskey)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/ethpalser/chirpy/internal/webhook"
+)
+
+type WebhookEndpointView struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDeliveryView struct {
+	ID            string                     `json:"id"`
+	EventID       string                     `json:"event_id"`
+	Event         string                     `json:"event"`
+	Status        string                     `json:"status"`
+	Attempts      []database.DeliveryAttempt `json:"attempts"`
+	NextAttemptAt *time.Time                 `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	RedeliveryOf  string                     `json:"redelivery_of,omitempty"`
+	Payload       json.RawMessage            `json:"payload"`
+}
+
+func webhookEndpointView(endpoint database.WebhookEndpoint) WebhookEndpointView {
+	return WebhookEndpointView{
+		ID:        endpoint.ID,
+		URL:       endpoint.URL,
+		Events:    endpoint.Events,
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
+
+func webhookDeliveryView(delivery database.WebhookDelivery) WebhookDeliveryView {
+	view := WebhookDeliveryView{
+		ID:           delivery.ID,
+		EventID:      delivery.EventID,
+		Event:        delivery.Event,
+		Status:       delivery.Status,
+		Attempts:     delivery.Attempts,
+		CreatedAt:    delivery.CreatedAt,
+		RedeliveryOf: delivery.RedeliveryOf,
+		Payload:      json.RawMessage(delivery.Payload),
+	}
+	if delivery.Status == database.DeliveryPending {
+		view.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	return view
+}
+
+// handlerWebhooksCreate registers an endpoint. While its owner is an admin it receives events
+// about every user, otherwise only about its owner.
+func (cfg *apiConfig) handlerWebhooksCreate(w http.ResponseWriter, r *http.Request) {
+	type WebhookRequest struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := WebhookRequest{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	// Plain http is only allowed for local testing
+	endpointURL, err := url.Parse(params.URL)
+	if err != nil || endpointURL.Host == "" || (endpointURL.Scheme != "https" && !(endpointURL.Scheme == "http" && cfg.platform == "dev")) {
+		responseWithError(w, http.StatusBadRequest, "url must be an absolute https url")
+		return
+	}
+	// Deliveries are checked again when they connect, this rejects an obviously internal url early
+	if cfg.platform != "dev" {
+		err = webhook.CheckHost(r.Context(), endpointURL.Hostname())
+		if errors.Is(err, webhook.ErrPrivateAddress) {
+			responseWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			responseWithError(w, http.StatusBadRequest, "url host could not be resolved")
+			return
+		}
+	}
+	if len(params.Events) == 0 {
+		responseWithError(w, http.StatusBadRequest, "at least one event is required")
+		return
+	}
+	for _, event := range params.Events {
+		if _, ok := webhookEvents[event]; !ok {
+			responseWithError(w, http.StatusBadRequest, "unknown event: "+event)
+			return
+		}
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	endpoint, err := cfg.database.CreateWebhookEndpoint(database.WebhookEndpoint{
+		UserID: userID,
+		URL:    endpointURL.String(),
+		Secret: secret,
+		Events: params.Events,
+	})
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.auditRequest(r, userActor(userID), auditWebhookCreated, audit.Target{Type: targetWebhook, ID: endpoint.ID}, []audit.Change{
+		{Field: "url", Before: nil, After: endpoint.URL},
+		{Field: "events", Before: nil, After: endpoint.Events},
+	})
+
+	// The secret is only shown once, it's needed to verify the Chirpy-Signature header
+	view := webhookEndpointView(endpoint)
+	view.Secret = endpoint.Secret
+	responseWithJSON(w, http.StatusCreated, view)
+}
+
+func (cfg *apiConfig) handlerWebhooksGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	endpoints, err := cfg.database.GetUserWebhookEndpoints(userID)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	views := make([]WebhookEndpointView, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		views = append(views, webhookEndpointView(endpoint))
+	}
+	responseWithJSON(w, http.StatusOK, views)
+}
+
+func (cfg *apiConfig) handlerWebhooksDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	endpoint, err := cfg.database.GetWebhookEndpoint(userID, r.PathValue("webhookID"))
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = cfg.database.DeleteWebhookEndpoint(userID, endpoint.ID)
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.auditRequest(r, userActor(userID), auditWebhookDeleted, audit.Target{Type: targetWebhook, ID: endpoint.ID}, []audit.Change{
+		{Field: "url", Before: endpoint.URL, After: nil},
+		{Field: "events", Before: endpoint.Events, After: nil},
+	})
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerWebhookDeliveriesGet returns the delivery log of one of the user's endpoints
+func (cfg *apiConfig) handlerWebhookDeliveriesGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	endpoint, err := cfg.database.GetWebhookEndpoint(userID, r.PathValue("webhookID"))
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	deliveries, err := cfg.database.GetWebhookDeliveries(endpoint.ID)
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	views := make([]WebhookDeliveryView, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		views = append(views, webhookDeliveryView(delivery))
+	}
+	responseWithJSON(w, http.StatusOK, views)
+}
+
+// handlerWebhookRedeliver queues the payload of an earlier delivery to be sent again
+func (cfg *apiConfig) handlerWebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.getAuthUserID(r)
+	if err != nil {
+		responseWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	endpoint, err := cfg.database.GetWebhookEndpoint(userID, r.PathValue("webhookID"))
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	delivery, err := cfg.database.RedeliverWebhook(endpoint.ID, r.PathValue("deliveryID"))
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "delivery not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	responseWithJSON(w, http.StatusAccepted, webhookDeliveryView(delivery))
+}
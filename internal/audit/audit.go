@@ -0,0 +1,141 @@
+// Package audit describes the append-only log of security and administrative actions: who did
+// what to which resource, from where, and what it changed.
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types of actor
+const (
+	ActorUser      = "user"
+	ActorWebhook   = "webhook"
+	ActorSystem    = "system"
+	ActorAnonymous = "anonymous"
+)
+
+// Redacted stands in for the value of a secret that changed
+const Redacted = "[redacted]"
+
+type Actor struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+}
+
+type Target struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type Entry struct {
+	ID        int       `json:"id"`
+	At        time.Time `json:"at"`
+	Actor     Actor     `json:"actor"`
+	Action    string    `json:"action"`
+	Target    Target    `json:"target"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// Reason is what caused the action when the actor alone doesn't say, such as the webhook event
+	Reason  string   `json:"reason,omitempty"`
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Diff lists the fields whose values differ between before and after, by field name. A field
+// missing from one side is compared as nil.
+func Diff(before map[string]interface{}, after map[string]interface{}) []Change {
+	fields := map[string]struct{}{}
+	for field := range before {
+		fields[field] = struct{}{}
+	}
+	for field := range after {
+		fields[field] = struct{}{}
+	}
+
+	changes := []Change{}
+	for field := range fields {
+		if !reflect.DeepEqual(before[field], after[field]) {
+			changes = append(changes, Change{Field: field, Before: before[field], After: after[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// Secret records that a secret field changed without recording its value
+func Secret(field string) Change {
+	return Change{Field: field, Before: Redacted, After: Redacted}
+}
+
+// Filter selects entries. Zero fields match everything, Action also matches the actions under
+// it, so "auth" matches "auth.login".
+type Filter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	// BeforeID pages backwards, only entries older than it match
+	BeforeID int
+	Limit    int
+}
+
+func (f Filter) Matches(entry Entry) bool {
+	if f.ActorID != "" && entry.Actor.ID != f.ActorID {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action && !strings.HasPrefix(entry.Action, f.Action+".") {
+		return false
+	}
+	if f.TargetType != "" && entry.Target.Type != f.TargetType {
+		return false
+	}
+	if f.TargetID != "" && entry.Target.ID != f.TargetID {
+		return false
+	}
+	if !f.Since.IsZero() && entry.At.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.At.Before(f.Until) {
+		return false
+	}
+	if f.BeforeID > 0 && entry.ID >= f.BeforeID {
+		return false
+	}
+	return true
+}
+
+// Record is one entry as a row of text, in the order of Columns, for exports
+func (e Entry) Record() []string {
+	changes := make([]string, len(e.Changes))
+	for i, change := range e.Changes {
+		changes[i] = fmt.Sprintf("%s: %v -> %v", change.Field, change.Before, change.After)
+	}
+	return []string{
+		fmt.Sprint(e.ID),
+		e.At.UTC().Format(time.RFC3339),
+		e.Actor.Type,
+		e.Actor.ID,
+		e.Action,
+		e.Target.Type,
+		e.Target.ID,
+		e.IP,
+		e.UserAgent,
+		e.Reason,
+		strings.Join(changes, "; "),
+	}
+}
+
+var Columns = []string{"id", "at", "actor_type", "actor_id", "action", "target_type", "target_id", "ip", "user_agent", "reason", "changes"}
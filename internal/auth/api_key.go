@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Scopes an API key can be granted. Access tokens from a login are not scoped.
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeProfileWrite = "profile:write"
+)
+
+var scopes = map[string]struct{}{
+	ScopeChirpsRead:   {},
+	ScopeChirpsWrite:  {},
+	ScopeProfileWrite: {},
+}
+
+const apiKeyPrefix = "chirpy"
+
+func ValidScope(scope string) bool {
+	_, ok := scopes[scope]
+	return ok
+}
+
+// GenerateAPIKey returns a new key formatted as chirpy_<id>_<secret>, and its id.
+// The id is public and used to look the key up; only a hash of the whole key should be stored.
+func GenerateAPIKey() (string, string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	idHex := hex.EncodeToString(id)
+	return apiKeyPrefix + "_" + idHex + "_" + hex.EncodeToString(secret), idHex, nil
+}
+
+// ParseAPIKeyID returns the id part of a key made by GenerateAPIKey
+func ParseAPIKeyID(key string) (string, bool) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
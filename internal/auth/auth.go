@@ -1,17 +1,39 @@
 package auth
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
 )
 
 func CreatePasswordHash(password string) (string, error) {
-	hashpass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return DefaultHasher.Hash(password)
+}
+
+// VerifyPasswordHash checks a password against a hash from any supported algorithm
+func VerifyPasswordHash(hashpass string, password string) error {
+	hasher, err := hasherFor(hashpass)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return string(hashpass), nil
+	return hasher.Verify(hashpass, password)
 }
 
-func VerifyPasswordHash(hashpass string, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashpass), []byte(password))
+var dummyHash string
+var dummyHashOnce sync.Once
+
+// CompareDummyHash takes as long as verifying a real password, so a login for an unknown user
+// can't be told apart from a wrong password by its response time
+func CompareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = CreatePasswordHash("chirpy-dummy-password")
+	})
+	VerifyPasswordHash(dummyHash, password)
+}
+
+// HashToken hashes a high entropy secret, such as a recovery code, for storage.
+// Unlike passwords these cannot be guessed, so a fast hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrMismatchedPassword = errors.New("password does not match")
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher is a password hashing algorithm. Its hashes encode the algorithm and parameters,
+// so they can be verified after the defaults change.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded string, password string) error
+	// Recognizes reports whether the hash was made by this algorithm
+	Recognizes(encoded string) bool
+	// Weaker reports whether the hash was made with weaker parameters than this hasher's
+	Weaker(encoded string) bool
+}
+
+// DefaultHasher hashes new passwords. Hashes made by anything else are upgraded on the next login.
+var DefaultHasher Hasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// hashers can verify existing hashes
+var hashers = []Hasher{
+	DefaultHasher,
+	BcryptHasher{Cost: bcrypt.DefaultCost},
+}
+
+func hasherFor(encoded string) (Hasher, error) {
+	for _, h := range hashers {
+		if h.Recognizes(encoded) {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownHash
+}
+
+// NeedsRehash reports whether a hash should be replaced with one from DefaultHasher
+func NeedsRehash(encoded string) bool {
+	return !DefaultHasher.Recognizes(encoded) || DefaultHasher.Weaker(encoded)
+}
+
+// Argon2idHasher encodes hashes in the PHC string format, $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(encoded string, password string) error {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	if subtle.ConstantTimeCompare(key, params.key) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+func (h Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h Argon2idHasher) Weaker(encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory < h.Memory ||
+		params.iterations < h.Iterations ||
+		params.parallelism < h.Parallelism ||
+		uint32(len(params.salt)) < h.SaltLength ||
+		uint32(len(params.key)) < h.KeyLength
+}
+
+func parseArgon2id(encoded string) (argon2idParams, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2idParams{}, ErrUnknownHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return argon2idParams{}, ErrUnknownHash
+	}
+
+	params := argon2idParams{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism)
+	if err != nil || params.iterations == 0 || params.parallelism == 0 {
+		return argon2idParams{}, ErrUnknownHash
+	}
+
+	b64 := base64.RawStdEncoding
+	params.salt, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return argon2idParams{}, ErrUnknownHash
+	}
+	params.key, err = b64.DecodeString(parts[5])
+	if err != nil || len(params.key) == 0 {
+		return argon2idParams{}, ErrUnknownHash
+	}
+	return params, nil
+}
+
+// BcryptHasher verifies the hashes chirpy stored before switching to Argon2id
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashpass, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashpass), nil
+}
+
+func (h BcryptHasher) Verify(encoded string, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatchedPassword
+	}
+	return err
+}
+
+func (h BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h BcryptHasher) Weaker(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
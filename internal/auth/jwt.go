@@ -6,7 +6,31 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func IssueJWT(secret string, subject string, expiresInSeconds int) (string, error) {
+// Roles a user can have, from least to most privileged
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Claims of an access token. Subject is the user id.
+type Claims struct {
+	Role string `json:"role,omitempty"`
+	// SessionID is the id of the refresh token the access token was issued with
+	SessionID string `json:"sid,omitempty"`
+	// AuthTime is when the user last entered their credentials, which refreshing does not change
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// Version must match the user's token version for the token to be accepted
+	Version int `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// IssueJWT signs the claims with an issuer, issue time and expiry set
+func IssueJWT(secret string, claims Claims, expiresInSeconds int) (string, error) {
 	var expireTime int
 	if 0 < expiresInSeconds && expiresInSeconds < 86400 {
 		expireTime = expiresInSeconds
@@ -14,14 +38,14 @@ func IssueJWT(secret string, subject string, expiresInSeconds int) (string, erro
 		expireTime = 86400
 	}
 	signingMethod := jwt.SigningMethodHS256
-	registeredClaims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Second * time.Duration(expireTime))),
-		Subject:   subject,
+	claims.Issuer = "chirpy"
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Second * time.Duration(expireTime)))
+	if claims.Role == "" {
+		claims.Role = RoleUser
 	}
 
-	token := jwt.NewWithClaims(signingMethod, registeredClaims)
+	token := jwt.NewWithClaims(signingMethod, claims)
 	return token.SignedString([]byte(secret))
 }
 
@@ -36,3 +60,17 @@ func ParseJWT(secret string, token string) (*jwt.Token, error) {
 	}
 	return jwt, nil
 }
+
+// ParseClaims verifies an access token and returns its claims
+func ParseClaims(secret string, token string) (*Claims, error) {
+	keyFunc := func(jwtToken *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, keyFunc, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrPasswordBreached = errors.New("password appears in a list of breached passwords, choose another")
+
+// PasswordPolicy is checked whenever a user chooses a password
+type PasswordPolicy struct {
+	MinLength int
+	// SHA-1 hashes, upper case hex, of passwords known to be breached
+	breached map[string]struct{}
+}
+
+// LoadPasswordPolicy reads the breached password list, if a path is given. Each line is either a password
+// or a SHA-1 hash as in the Have I Been Pwned downloads, optionally followed by ':<count>'.
+func LoadPasswordPolicy(minLength int, breachedPath string) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		MinLength: minLength,
+		breached:  map[string]struct{}{},
+	}
+	if breachedPath == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(breachedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			policy.breached[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		policy.breached[sha1Hex(line)] = struct{}{}
+	}
+	return policy, scanner.Err()
+}
+
+func (p *PasswordPolicy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
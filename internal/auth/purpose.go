@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrWrongPurpose = errors.New("token is not valid for this purpose")
+
+// PurposeClaims are the claims of a short-lived token issued for one purpose, such as verifying an email
+type PurposeClaims struct {
+	Purpose string            `json:"purpose"`
+	Data    map[string]string `json:"data,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// purposeKey derives a signing key per purpose, so a token for one purpose is never accepted for another
+// or as an access token
+func purposeKey(secret string, purpose string) []byte {
+	return []byte(secret + ":" + purpose)
+}
+
+func IssuePurposeToken(secret string, purpose string, subject string, ttl time.Duration, data map[string]string) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := PurposeClaims{
+		Purpose: purpose,
+		Data:    data,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			ID:        hex.EncodeToString(b),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			Subject:   subject,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(purposeKey(secret, purpose))
+}
+
+func ParsePurposeToken(secret string, purpose string, token string) (*PurposeClaims, error) {
+	keyFunc := func(jwtToken *jwt.Token) (interface{}, error) {
+		return purposeKey(secret, purpose), nil
+	}
+
+	claims := &PurposeClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, keyFunc, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrWrongPurpose
+	}
+	return claims, nil
+}
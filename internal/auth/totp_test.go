@@ -0,0 +1,15 @@
+package auth
+
+import "testing"
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashToken("abcde-fghij")
+	for _, code := range []string{"abcde-fghij", "ABCDE-FGHIJ", "abcdefghij", " abcde fghij "} {
+		if got := HashRecoveryCode(code); got != want {
+			t.Errorf("HashRecoveryCode(%q) does not match the stored code", code)
+		}
+	}
+	if HashRecoveryCode("abcde-fghik") == want {
+		t.Error("a different code matches")
+	}
+}
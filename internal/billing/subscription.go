@@ -0,0 +1,145 @@
+// Package billing holds the Chirpy Red subscription lifecycle, independent of where it is stored
+package billing
+
+import (
+	"errors"
+	"time"
+)
+
+const PlanRed = "red"
+
+// DefaultPeriod is how long a payment lasts when the event doesn't say
+const DefaultPeriod = 30 * 24 * time.Hour
+
+// Statuses of a subscription. Active and past due subscriptions are open, the others are closed
+// until the user upgrades again.
+const (
+	StatusActive   = "active"
+	StatusPastDue  = "past_due"
+	StatusCanceled = "canceled"
+	StatusExpired  = "expired"
+)
+
+// Events that change a subscription. EventExpired is raised by the expiry job, the rest come from Polka.
+const (
+	EventUpgraded      = "user.upgraded"
+	EventDowngraded    = "user.downgraded"
+	EventPaymentFailed = "payment.failed"
+	EventRenewed       = "subscription.renewed"
+	EventExpired       = "subscription.expired"
+)
+
+var ErrUnknownEvent = errors.New("unknown subscription event")
+var ErrNotSubscribed = errors.New("user has no open subscription")
+var ErrStaleEvent = errors.New("event is older than the last change to the subscription")
+
+type Subscription struct {
+	Plan             string     `json:"plan"`
+	Status           string     `json:"status"`
+	CurrentPeriodEnd time.Time  `json:"current_period_end"`
+	GraceUntil       *time.Time `json:"grace_until,omitempty"`
+	// when the last applied event happened, by the provider's clock
+	LastEventAt *time.Time `json:"last_event_at,omitempty"`
+}
+
+// Change is what an event says about the subscription, all fields are optional
+type Change struct {
+	Plan      string
+	PeriodEnd *time.Time
+	// At is when the event happened. Events can arrive out of order, so one older than the
+	// last applied event is stale.
+	At *time.Time
+}
+
+// Policy decides how long a subscription stays premium past its paid period
+type Policy struct {
+	// Grace after a failed payment for the user to fix it
+	PaymentGrace time.Duration
+	// Leeway after the period ends for a renewal event to arrive
+	RenewalLeeway time.Duration
+}
+
+// Apply returns the subscription after the event. sub is the zero value for a user that never subscribed.
+func (p Policy) Apply(sub Subscription, event string, change Change, now time.Time) (Subscription, error) {
+	if change.At != nil && sub.LastEventAt != nil && change.At.Before(*sub.LastEventAt) {
+		return sub, ErrStaleEvent
+	}
+
+	switch event {
+	case EventUpgraded, EventRenewed:
+		if event == EventRenewed && !sub.Open() {
+			return sub, ErrNotSubscribed
+		}
+		if change.Plan != "" {
+			sub.Plan = change.Plan
+		}
+		if sub.Plan == "" {
+			sub.Plan = PlanRed
+		}
+		// A renewal without a period end extends the current period rather than starting from now
+		start := now
+		if sub.Open() && sub.CurrentPeriodEnd.After(now) {
+			start = sub.CurrentPeriodEnd
+		}
+		if change.PeriodEnd != nil {
+			sub.CurrentPeriodEnd = *change.PeriodEnd
+		} else {
+			sub.CurrentPeriodEnd = start.Add(DefaultPeriod)
+		}
+		sub.Status = StatusActive
+		sub.GraceUntil = nil
+	case EventPaymentFailed:
+		if !sub.Open() {
+			return sub, ErrNotSubscribed
+		}
+		if sub.GraceUntil == nil {
+			graceUntil := now.Add(p.PaymentGrace)
+			if sub.CurrentPeriodEnd.After(now) {
+				graceUntil = sub.CurrentPeriodEnd.Add(p.PaymentGrace)
+			}
+			sub.GraceUntil = &graceUntil
+		}
+		sub.Status = StatusPastDue
+	case EventDowngraded:
+		if !sub.Open() {
+			return sub, ErrNotSubscribed
+		}
+		sub.Status = StatusCanceled
+		sub.GraceUntil = nil
+	case EventExpired:
+		sub.Status = StatusExpired
+		sub.GraceUntil = nil
+	default:
+		return sub, ErrUnknownEvent
+	}
+	if change.At != nil {
+		sub.LastEventAt = change.At
+	}
+	return sub, nil
+}
+
+// Legacy is the subscription of a user who got Chirpy Red before subscriptions were recorded.
+// It is open, with a period ending now, so a renewal starts a full period from now.
+func Legacy(now time.Time) Subscription {
+	return Subscription{Plan: PlanRed, Status: StatusActive, CurrentPeriodEnd: now}
+}
+
+// Premium reports whether the subscription grants Chirpy Red at the given time
+func (p Policy) Premium(sub Subscription, now time.Time) bool {
+	switch sub.Status {
+	case StatusActive:
+		return now.Before(sub.CurrentPeriodEnd.Add(p.RenewalLeeway))
+	case StatusPastDue:
+		return sub.GraceUntil != nil && now.Before(*sub.GraceUntil)
+	}
+	return false
+}
+
+// Lapsed reports whether an open subscription ran out and should be expired
+func (p Policy) Lapsed(sub Subscription, now time.Time) bool {
+	return sub.Open() && !p.Premium(sub, now)
+}
+
+func (s Subscription) Open() bool {
+	return s.Status == StatusActive || s.Status == StatusPastDue
+}
@@ -0,0 +1,182 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	policy := Policy{PaymentGrace: 7 * 24 * time.Hour, RenewalLeeway: time.Hour}
+	active := Subscription{Plan: PlanRed, Status: StatusActive, CurrentPeriodEnd: now.Add(10 * 24 * time.Hour)}
+	pastDue := Subscription{Plan: PlanRed, Status: StatusPastDue, CurrentPeriodEnd: active.CurrentPeriodEnd, GraceUntil: at(17 * 24 * time.Hour)}
+	canceled := Subscription{Plan: PlanRed, Status: StatusCanceled, CurrentPeriodEnd: active.CurrentPeriodEnd, LastEventAt: at(-time.Minute)}
+
+	tests := []struct {
+		name    string
+		sub     Subscription
+		event   string
+		change  Change
+		want    Subscription
+		wantErr error
+	}{
+		{
+			name:  "first upgrade",
+			event: EventUpgraded,
+			want:  Subscription{Plan: PlanRed, Status: StatusActive, CurrentPeriodEnd: now.Add(DefaultPeriod)},
+		},
+		{
+			name:   "upgrade with a period end and plan",
+			event:  EventUpgraded,
+			change: Change{Plan: "red-yearly", PeriodEnd: at(365 * 24 * time.Hour)},
+			want:   Subscription{Plan: "red-yearly", Status: StatusActive, CurrentPeriodEnd: now.Add(365 * 24 * time.Hour)},
+		},
+		{
+			name:  "renewal extends the current period",
+			sub:   active,
+			event: EventRenewed,
+			want:  Subscription{Plan: PlanRed, Status: StatusActive, CurrentPeriodEnd: active.CurrentPeriodEnd.Add(DefaultPeriod)},
+		},
+		{
+			name:  "renewal clears a failed payment",
+			sub:   pastDue,
+			event: EventRenewed,
+			want:  Subscription{Plan: PlanRed, Status: StatusActive, CurrentPeriodEnd: active.CurrentPeriodEnd.Add(DefaultPeriod)},
+		},
+		{
+			name:    "renewal without a subscription",
+			event:   EventRenewed,
+			wantErr: ErrNotSubscribed,
+		},
+		{
+			name:  "payment failure grants grace after the period",
+			sub:   active,
+			event: EventPaymentFailed,
+			want:  pastDue,
+		},
+		{
+			name:  "repeated payment failure keeps the grace",
+			sub:   pastDue,
+			event: EventPaymentFailed,
+			want:  pastDue,
+		},
+		{
+			name:    "payment failure after cancelling",
+			sub:     canceled,
+			event:   EventPaymentFailed,
+			wantErr: ErrNotSubscribed,
+		},
+		{
+			name:  "downgrade",
+			sub:   pastDue,
+			event: EventDowngraded,
+			want:  Subscription{Plan: PlanRed, Status: StatusCanceled, CurrentPeriodEnd: active.CurrentPeriodEnd},
+		},
+		{
+			name:    "downgrade without a subscription",
+			event:   EventDowngraded,
+			wantErr: ErrNotSubscribed,
+		},
+		{
+			name:  "expiry",
+			sub:   pastDue,
+			event: EventExpired,
+			want:  Subscription{Plan: PlanRed, Status: StatusExpired, CurrentPeriodEnd: active.CurrentPeriodEnd},
+		},
+		{
+			name:  "legacy subscription can be downgraded",
+			sub:   Legacy(now),
+			event: EventDowngraded,
+			want:  Subscription{Plan: PlanRed, Status: StatusCanceled, CurrentPeriodEnd: now},
+		},
+		{
+			name:   "event records when it happened",
+			sub:    active,
+			event:  EventDowngraded,
+			change: Change{At: at(0)},
+			want:   Subscription{Plan: PlanRed, Status: StatusCanceled, CurrentPeriodEnd: active.CurrentPeriodEnd, LastEventAt: at(0)},
+		},
+		{
+			name:    "upgrade older than a downgrade",
+			sub:     canceled,
+			event:   EventUpgraded,
+			change:  Change{At: at(-time.Hour)},
+			wantErr: ErrStaleEvent,
+		},
+		{
+			name:   "upgrade newer than a downgrade",
+			sub:    canceled,
+			event:  EventUpgraded,
+			change: Change{At: at(0)},
+			want:   Subscription{Plan: PlanRed, Status: StatusActive, CurrentPeriodEnd: now.Add(DefaultPeriod), LastEventAt: at(0)},
+		},
+		{
+			name:    "unknown event",
+			sub:     active,
+			event:   "user.renamed",
+			wantErr: ErrUnknownEvent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Apply(tt.sub, tt.event, tt.change, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !equal(got, tt.want) {
+				t.Fatalf("Apply = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPremium(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	policy := Policy{PaymentGrace: 7 * 24 * time.Hour, RenewalLeeway: time.Hour}
+	graceUntil := now.Add(time.Minute)
+	graceOver := now.Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		sub  Subscription
+		want bool
+	}{
+		{name: "never subscribed", sub: Subscription{}, want: false},
+		{name: "active", sub: Subscription{Status: StatusActive, CurrentPeriodEnd: now.Add(time.Hour)}, want: true},
+		{name: "active within the leeway", sub: Subscription{Status: StatusActive, CurrentPeriodEnd: now.Add(-30 * time.Minute)}, want: true},
+		{name: "active past the leeway", sub: Subscription{Status: StatusActive, CurrentPeriodEnd: now.Add(-2 * time.Hour)}, want: false},
+		{name: "past due in grace", sub: Subscription{Status: StatusPastDue, GraceUntil: &graceUntil}, want: true},
+		{name: "past due after grace", sub: Subscription{Status: StatusPastDue, GraceUntil: &graceOver}, want: false},
+		{name: "canceled", sub: Subscription{Status: StatusCanceled, CurrentPeriodEnd: now.Add(time.Hour)}, want: false},
+		{name: "legacy", sub: Legacy(now), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Premium(tt.sub, now); got != tt.want {
+				t.Fatalf("Premium = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equal(a Subscription, b Subscription) bool {
+	return a.Plan == b.Plan && a.Status == b.Status && a.CurrentPeriodEnd.Equal(b.CurrentPeriodEnd) &&
+		equalTime(a.GraceUntil, b.GraceUntil) && equalTime(a.LastEventAt, b.LastEventAt)
+}
+
+func equalTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,118 @@
+// Package chirptext cleans and measures the text of a chirp the way a reader sees it: lengths
+// count user-perceived characters rather than bytes, and text that would render invisibly or
+// differently than it reads is rejected.
+package chirptext
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Units a chirp's length can be counted in
+const (
+	// UnitGraphemes counts what a reader sees as one character, so a flag or a family emoji counts once
+	UnitGraphemes  = "graphemes"
+	UnitCodePoints = "code_points"
+)
+
+var (
+	ErrEmpty     = errors.New("chirp is empty")
+	ErrInvisible = errors.New("chirp contains control or invisible characters")
+	ErrTooDense  = errors.New("chirp contains a character built from too many code points")
+)
+
+// maxClusterRunes is the most code points one user-perceived character may have. The longest
+// emoji sequences, such as a family with skin tones, have 11, but stacking combining marks on a
+// letter has no limit and would let a chirp of a few characters be arbitrarily large.
+const maxClusterRunes = 16
+
+// Rules a chirp must follow, such as those of its author's plan
+type Rules struct {
+	MaxLength int
+	Unit      string
+}
+
+// ValidUnit reports whether unit is one lengths can be counted in, the empty unit means graphemes
+func ValidUnit(unit string) bool {
+	return unit == "" || unit == UnitGraphemes || unit == UnitCodePoints
+}
+
+// Clean normalizes msg to NFC, with line endings as \n and surrounding whitespace trimmed, then
+// checks it against the rules. It returns the text to store.
+func Clean(msg string, rules Rules) (string, error) {
+	msg = norm.NFC.String(msg)
+	msg = strings.ReplaceAll(msg, "\r\n", "\n")
+	msg = strings.TrimFunc(msg, unicode.IsSpace)
+	if msg == "" {
+		return "", ErrEmpty
+	}
+
+	err := checkClusters(msg)
+	if err != nil {
+		return "", err
+	}
+
+	if length := Length(msg, rules.Unit); length > rules.MaxLength {
+		return "", fmt.Errorf("chirp is too long, the limit is %d characters and it has %d", rules.MaxLength, length)
+	}
+	return msg, nil
+}
+
+// Length counts msg in unit
+func Length(msg string, unit string) int {
+	if unit == UnitCodePoints {
+		return len([]rune(msg))
+	}
+	return uniseg.GraphemeClusterCount(msg)
+}
+
+// checkClusters rejects characters with more than maxClusterRunes code points, and text that
+// reads differently than it renders: control characters other than newlines, format characters
+// such as zero width spaces and bidi overrides, and letters that render as blanks. Zero width
+// joiners and tag characters are allowed inside a grapheme, where they build emoji sequences,
+// but not on their own.
+func checkClusters(msg string) error {
+	state := -1
+	rest := msg
+	var cluster string
+	for len(rest) > 0 {
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		if utf8.RuneCountInString(cluster) > maxClusterRunes {
+			return ErrTooDense
+		}
+		first, _ := utf8.DecodeRuneInString(cluster)
+		for _, r := range cluster {
+			if r == '\n' || r == '\t' {
+				continue
+			}
+			if unicode.IsControl(r) || r == '\u2028' || r == '\u2029' || blank(r) {
+				return ErrInvisible
+			}
+			// A joiner needs something visible before it in its grapheme to join
+			if unicode.Is(unicode.Cf, r) && (!joiner(r) || r == first || unicode.IsSpace(first)) {
+				return ErrInvisible
+			}
+		}
+	}
+	return nil
+}
+
+// joiner is a format character that is part of an emoji sequence
+func joiner(r rune) bool {
+	return r == '\u200d' || (r >= 0xe0020 && r <= 0xe007f)
+}
+
+// blank is a letter or symbol that renders as empty space without being whitespace
+func blank(r rune) bool {
+	switch r {
+	case '\u115f', '\u1160', '\u3164', '\uffa0', '\u2800':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package chirptext
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	rules := Rules{MaxLength: 10, Unit: UnitGraphemes}
+
+	tests := []struct {
+		name    string
+		msg     string
+		rules   Rules
+		want    string
+		wantErr error
+	}{
+		{name: "plain", msg: "hello", want: "hello"},
+		{name: "trims whitespace", msg: "  hello \n", want: "hello"},
+		{name: "normalizes line endings", msg: "one\r\ntwo", want: "one\ntwo"},
+		{name: "composes to nfc", msg: "cafe\u0301", want: "caf\u00e9"},
+		{name: "empty", msg: "", wantErr: ErrEmpty},
+		{name: "only whitespace", msg: " \t\n ", wantErr: ErrEmpty},
+		{name: "control character", msg: "bell\u0007", wantErr: ErrInvisible},
+		{name: "zero width space", msg: "zero\u200bwidth", wantErr: ErrInvisible},
+		{name: "bidi override", msg: "abc\u202edef", wantErr: ErrInvisible},
+		{name: "blank letter", msg: "hi\u3164", wantErr: ErrInvisible},
+		{name: "joiner on its own", msg: "\u200d", wantErr: ErrInvisible},
+		{name: "joiner after a space", msg: "a \u200db", wantErr: ErrInvisible},
+		{name: "emoji sequence", msg: "👨‍👩‍👧", want: "👨‍👩‍👧"},
+		{name: "family with skin tones", msg: "👨🏻‍👩🏼‍👧🏽‍👦🏾", want: "👨🏻‍👩🏼‍👧🏽‍👦🏾"},
+		{name: "tag flag", msg: "🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f", want: "🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f"},
+		{name: "a few combining marks", msg: "a\u0301\u0302\u0303", want: "\u00e1\u0302\u0303"},
+		{name: "stacked combining marks", msg: "a" + strings.Repeat("\u0301", 40), wantErr: ErrTooDense},
+		{name: "at the limit", msg: strings.Repeat("\u00e9", 10), want: strings.Repeat("\u00e9", 10)},
+		{name: "emoji count once", msg: strings.Repeat("🇨🇦", 10), want: strings.Repeat("🇨🇦", 10)},
+		{name: "over the limit", msg: strings.Repeat("a", 11), wantErr: errTooLong},
+		{
+			name:    "code points counts each flag twice",
+			msg:     strings.Repeat("🇨🇦", 6),
+			rules:   Rules{MaxLength: 10, Unit: UnitCodePoints},
+			wantErr: errTooLong,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.rules
+			if r.MaxLength == 0 {
+				r = rules
+			}
+			got, err := Clean(tt.msg, r)
+			if tt.wantErr == errTooLong {
+				if err == nil || !strings.Contains(err.Error(), "too long") {
+					t.Fatalf("Clean error = %v, want a too long error", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Clean error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Clean = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// errTooLong stands in for the length error, which says the limit and so isn't a sentinel
+var errTooLong = errors.New("too long")
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		msg        string
+		graphemes  int
+		codePoints int
+	}{
+		{msg: "hello", graphemes: 5, codePoints: 5},
+		{msg: "caf\u00e9", graphemes: 4, codePoints: 4},
+		{msg: "cafe\u0301", graphemes: 4, codePoints: 5},
+		{msg: "🇨🇦", graphemes: 1, codePoints: 2},
+		{msg: "👨‍👩‍👧", graphemes: 1, codePoints: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := Length(tt.msg, UnitGraphemes); got != tt.graphemes {
+				t.Fatalf("Length(%q, graphemes) = %d, want %d", tt.msg, got, tt.graphemes)
+			}
+			if got := Length(tt.msg, UnitCodePoints); got != tt.codePoints {
+				t.Fatalf("Length(%q, code points) = %d, want %d", tt.msg, got, tt.codePoints)
+			}
+		})
+	}
+}
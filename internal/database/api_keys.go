@@ -0,0 +1,105 @@
+package database
+
+import (
+	"crypto/subtle"
+	"sort"
+	"time"
+)
+
+// APIKey is a long-lived credential a user creates for bots and integrations.
+// Only a hash of the key is stored.
+type APIKey struct {
+	ID        string     `json:"id"`
+	UserID    int        `json:"user_id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// CreateAPIKey stores the key, returning ErrLimitReached if the user already has maxActive keys
+// that have not expired
+func (db *DB) CreateAPIKey(key APIKey, maxActive int) (APIKey, error) {
+	err := db.update(func(data *DBStructure) error {
+		if _, exists := data.APIKeys[key.ID]; exists {
+			return ErrConflict
+		}
+		if _, ok := data.Users[key.UserID]; !ok {
+			return ErrNotExist
+		}
+		now := time.Now()
+		active := 0
+		for _, existing := range data.APIKeys {
+			if existing.UserID == key.UserID && (existing.ExpiresAt == nil || existing.ExpiresAt.After(now)) {
+				active++
+			}
+		}
+		if active >= maxActive {
+			return ErrLimitReached
+		}
+
+		key.CreatedAt = now
+		data.APIKeys[key.ID] = key
+		return nil
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetUserAPIKeys returns the user's keys, newest first, including expired ones
+func (db *DB) GetUserAPIKeys(userID int) ([]APIKey, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	for _, key := range data.APIKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (db *DB) DeleteAPIKey(userID int, id string) error {
+	return db.update(func(data *DBStructure) error {
+		key, ok := data.APIKeys[id]
+		if !ok || key.UserID != userID {
+			return ErrNotExist
+		}
+		delete(data.APIKeys, id)
+		return nil
+	})
+}
+
+// UseAPIKey finds the key with the id, checks it matches the hash and has not expired, and records its use
+func (db *DB) UseAPIKey(id string, hash string) (APIKey, error) {
+	var key APIKey
+	err := db.update(func(data *DBStructure) error {
+		var ok bool
+		key, ok = data.APIKeys[id]
+		if !ok || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) != 1 {
+			return ErrUnauthorized
+		}
+		now := time.Now()
+		if key.ExpiresAt != nil && key.ExpiresAt.Before(now) {
+			return ErrUnauthorized
+		}
+
+		key.LastUsed = &now
+		data.APIKeys[id] = key
+		return nil
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	return key, nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKeyLimit(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	user, err := db.CreateUser("bot@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	_, err = db.CreateAPIKey(APIKey{ID: "expired", UserID: user.Id, ExpiresAt: &expired}, 3)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.CreateAPIKey(APIKey{ID: fmt.Sprint(i), UserID: user.Id}, 3)
+			if err == nil {
+				created.Add(1)
+			} else if !errors.Is(err, ErrLimitReached) {
+				t.Errorf("CreateAPIKey: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created.Load() != 3 {
+		t.Fatalf("created %d keys, want 3 as expired keys don't count", created.Load())
+	}
+}
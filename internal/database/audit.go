@@ -0,0 +1,48 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+)
+
+// The audit log is append-only: entries are never changed or removed, not even when the user
+// they are about is deleted.
+
+// AppendAuditEntry adds the entry to the end of the log, numbering it after the last one
+func (db *DB) AppendAuditEntry(entry audit.Entry) (audit.Entry, error) {
+	err := db.update(func(data *DBStructure) error {
+		entry.ID = 1
+		if last := len(data.AuditLog); last > 0 {
+			entry.ID = data.AuditLog[last-1].ID + 1
+		}
+		if entry.At.IsZero() {
+			entry.At = time.Now()
+		}
+		data.AuditLog = append(data.AuditLog, entry)
+		return nil
+	})
+	if err != nil {
+		return audit.Entry{}, err
+	}
+	return entry, nil
+}
+
+// GetAuditEntries returns the entries matching the filter, newest first. A limit of zero returns all of them.
+func (db *DB) GetAuditEntries(filter audit.Filter) ([]audit.Entry, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []audit.Entry{}
+	for i := len(data.AuditLog) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+		if filter.Matches(data.AuditLog[i]) {
+			entries = append(entries, data.AuditLog[i])
+		}
+	}
+	return entries, nil
+}
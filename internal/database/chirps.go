@@ -4,6 +4,7 @@ import (
 	"errors"
 	"sort"
 
+	"github.com/ethpalser/chirpy/internal/outbox"
 	"github.com/ethpalser/chirpy/internal/util"
 )
 
@@ -13,29 +14,36 @@ type Chirp struct {
 	AuthorID int    `json:"user_id"`
 }
 
+// ChirpEvent builds the outbox event for a chirp that was just written
+type ChirpEvent func(chirp Chirp) (outbox.Event, error)
+
 type ChirpOptions struct {
 	AuthorID int
 	SortAsc  bool
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	data, err := db.loadDB()
+// CreateChirp records the event built by newEvent in the outbox, in the same write as the chirp
+func (db *DB) CreateChirp(body string, authorID int, newEvent ChirpEvent) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(data *DBStructure) error {
+		id := nextID(data.Chirps)
+		chirp = Chirp{
+			ID:       id,
+			Message:  body,
+			AuthorID: authorID,
+		}
+		data.Chirps[id] = chirp
+		event, err := newEvent(chirp)
+		if err != nil {
+			return err
+		}
+		data.Outbox[event.ID] = event
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	id := len(data.Chirps) + 1
-	chirp := Chirp{
-		ID:       id,
-		Message:  body,
-		AuthorID: authorID,
-	}
-	data.Chirps[id] = chirp
-	wErr := db.writeDB(data)
-	if wErr != nil {
-		return Chirp{}, wErr
-	}
-
 	return chirp, nil
 }
 
@@ -81,22 +89,21 @@ func (db *DB) GetChirps(opts ChirpOptions) ([]Chirp, error) {
 	return chirps, err
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	data, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	_, exists := data.Chirps[id]
-	if !exists {
-		return ErrNotExist
-	}
+// DeleteChirp records the event built by newEvent in the outbox, in the same write as the delete
+func (db *DB) DeleteChirp(id int, newEvent ChirpEvent) error {
+	return db.update(func(data *DBStructure) error {
+		chirp, exists := data.Chirps[id]
+		if !exists {
+			return ErrNotExist
+		}
 
-	// hard delete
-	delete(data.Chirps, id)
-	wErr := db.writeDB(data)
-	if wErr != nil {
-		return wErr
-	}
-	return nil
+		// hard delete
+		delete(data.Chirps, id)
+		event, err := newEvent(chirp)
+		if err != nil {
+			return err
+		}
+		data.Outbox[event.ID] = event
+		return nil
+	})
 }
@@ -5,11 +5,18 @@ import (
 	"errors"
 	"os"
 	"sync"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/jobs"
+	"github.com/ethpalser/chirpy/internal/outbox"
 )
 
 var ErrConflict = errors.New("conflict with existing resource")
 var ErrNotExist = errors.New("resource does not exist")
 var ErrUnauthorized = errors.New("unauthorized access")
+var ErrInvalidEmail = errors.New("invalid email address")
+var ErrLimitReached = errors.New("limit reached")
 
 type DB struct {
 	path string
@@ -20,6 +27,22 @@ type DBStructure struct {
 	Chirps map[int]Chirp    `json:"chirps"`
 	Users  map[int]User     `json:"users"`
 	Tokens map[string]Token `json:"tokens"`
+	// ids of single-use tokens that were already used, until they expire
+	UsedTokens map[string]time.Time `json:"used_tokens"`
+	Passkeys   map[string]Passkey   `json:"passkeys"`
+	APIKeys    map[string]APIKey    `json:"api_keys"`
+	Exports    map[int]Export       `json:"exports"`
+	// received webhook deliveries, keyed by source and event id
+	WebhookEvents map[string]WebhookEvent `json:"webhook_events"`
+	Subscriptions map[int]Subscription    `json:"subscriptions"`
+	// outgoing webhooks, deliveries are both the queue and the delivery log
+	WebhookEndpoints  map[string]WebhookEndpoint `json:"webhook_endpoints"`
+	WebhookDeliveries map[string]WebhookDelivery `json:"webhook_deliveries"`
+	Jobs              map[string]jobs.Job        `json:"jobs"`
+	// events written with the change that caused them, until dispatched
+	Outbox map[string]outbox.Event `json:"outbox"`
+	// append-only, oldest first
+	AuditLog []audit.Entry `json:"audit_log"`
 }
 
 func NewDB(path string) (*DB, error) {
@@ -32,12 +55,26 @@ func NewDB(path string) (*DB, error) {
 }
 
 func (db *DB) createDB() error {
-	dbStructure := DBStructure{
-		Chirps: map[int]Chirp{},
-		Users:  map[int]User{},
-		Tokens: map[string]Token{},
+	return db.writeDB(emptyDB())
+}
+
+func emptyDB() DBStructure {
+	return DBStructure{
+		Chirps:            map[int]Chirp{},
+		Users:             map[int]User{},
+		Tokens:            map[string]Token{},
+		UsedTokens:        map[string]time.Time{},
+		Passkeys:          map[string]Passkey{},
+		APIKeys:           map[string]APIKey{},
+		Exports:           map[int]Export{},
+		WebhookEvents:     map[string]WebhookEvent{},
+		Subscriptions:     map[int]Subscription{},
+		WebhookEndpoints:  map[string]WebhookEndpoint{},
+		WebhookDeliveries: map[string]WebhookDelivery{},
+		Jobs:              map[string]jobs.Job{},
+		Outbox:            map[string]outbox.Event{},
+		AuditLog:          []audit.Entry{},
 	}
-	return db.writeDB(dbStructure)
 }
 
 func (db *DB) ensureDB() error {
@@ -48,18 +85,30 @@ func (db *DB) ensureDB() error {
 	return err
 }
 
+// ResetDB empties the db except for the audit log, which must outlive everything it records
 func (db *DB) ResetDB() error {
-	err := os.Remove(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return nil
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	reset := emptyDB()
+	data, err := db.readDB()
+	if err == nil {
+		reset.AuditLog = data.AuditLog
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
 	}
-	return db.ensureDB()
+	return db.saveDB(reset)
 }
 
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 
+	return db.readDB()
+}
+
+// readDB loads the db without locking, the caller must hold mux
+func (db *DB) readDB() (DBStructure, error) {
 	dbStructure := DBStructure{}
 	file, err := os.ReadFile(db.path)
 	if errors.Is(err, os.ErrNotExist) {
@@ -70,6 +119,40 @@ func (db *DB) loadDB() (DBStructure, error) {
 	if jsonErr != nil {
 		return dbStructure, jsonErr
 	}
+	// Sections added after a db file was created are missing from it
+	if dbStructure.UsedTokens == nil {
+		dbStructure.UsedTokens = map[string]time.Time{}
+	}
+	if dbStructure.Passkeys == nil {
+		dbStructure.Passkeys = map[string]Passkey{}
+	}
+	if dbStructure.APIKeys == nil {
+		dbStructure.APIKeys = map[string]APIKey{}
+	}
+	if dbStructure.Exports == nil {
+		dbStructure.Exports = map[int]Export{}
+	}
+	if dbStructure.WebhookEvents == nil {
+		dbStructure.WebhookEvents = map[string]WebhookEvent{}
+	}
+	if dbStructure.Subscriptions == nil {
+		dbStructure.Subscriptions = map[int]Subscription{}
+	}
+	if dbStructure.WebhookEndpoints == nil {
+		dbStructure.WebhookEndpoints = map[string]WebhookEndpoint{}
+	}
+	if dbStructure.WebhookDeliveries == nil {
+		dbStructure.WebhookDeliveries = map[string]WebhookDelivery{}
+	}
+	if dbStructure.Jobs == nil {
+		dbStructure.Jobs = map[string]jobs.Job{}
+	}
+	if dbStructure.Outbox == nil {
+		dbStructure.Outbox = map[string]outbox.Event{}
+	}
+	if dbStructure.AuditLog == nil {
+		dbStructure.AuditLog = []audit.Entry{}
+	}
 
 	return dbStructure, nil
 }
@@ -78,6 +161,34 @@ func (db *DB) writeDB(dbStructure DBStructure) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
+	return db.saveDB(dbStructure)
+}
+
+// errNoChange is returned from an update's change to skip writing the db, without failing the update
+var errNoChange = errors.New("nothing changed")
+
+// update holds the write lock from loading the db until the changed db is written, so concurrent
+// mutations can't overwrite each other's changes. Nothing is written if change returns an error.
+func (db *DB) update(change func(data *DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.readDB()
+	if err != nil {
+		return err
+	}
+	err = change(&data)
+	if errors.Is(err, errNoChange) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.saveDB(data)
+}
+
+// saveDB writes the db without locking, the caller must hold mux
+func (db *DB) saveDB(dbStructure DBStructure) error {
 	dbJSON, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
@@ -85,3 +196,12 @@ func (db *DB) writeDB(dbStructure DBStructure) error {
 
 	return os.WriteFile(db.path, []byte(dbJSON), 0644)
 }
+
+// nextID returns an id one higher than any in use, so ids are not reused after a delete
+func nextID[T any](items map[int]T) int {
+	highest := 0
+	for id := range items {
+		highest = max(highest, id)
+	}
+	return highest + 1
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"time"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// Export is a user's request for a copy of their data. Each user has at most one.
+type Export struct {
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	Path        string     `json:"path,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+func (db *DB) GetExport(userID int) (Export, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return Export{}, err
+	}
+
+	export, ok := data.Exports[userID]
+	if !ok {
+		return Export{}, ErrNotExist
+	}
+	return export, nil
+}
+
+// SaveExport creates or replaces the user's export
+func (db *DB) SaveExport(export Export) error {
+	return db.update(func(data *DBStructure) error {
+		if _, ok := data.Users[export.UserID]; !ok {
+			return ErrNotExist
+		}
+		data.Exports[export.UserID] = export
+		return nil
+	})
+}
+
+// GetUserData returns everything stored about the user in the json db, for an export
+func (db *DB) GetUserData(userID int) (User, []Chirp, []Token, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return User{}, nil, nil, err
+	}
+
+	user, ok := data.Users[userID]
+	if !ok {
+		return User{}, nil, nil, ErrNotExist
+	}
+	chirps := []Chirp{}
+	for _, chirp := range data.Chirps {
+		if chirp.AuthorID == userID {
+			chirps = append(chirps, chirp)
+		}
+	}
+	tokens := []Token{}
+	for _, token := range data.Tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	return user, chirps, tokens, nil
+}
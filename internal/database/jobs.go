@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/jobs"
+	"github.com/google/uuid"
+)
+
+// The json db implements jobs.Store. Claims are made in a single locked update so two workers
+// can never claim the same job.
+
+func (db *DB) EnqueueJob(ctx context.Context, job jobs.Job) (jobs.Job, error) {
+	now := time.Now()
+	job.ID = uuid.NewString()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	err := db.update(func(data *DBStructure) error {
+		data.Jobs[job.ID] = job
+		return nil
+	})
+	if err != nil {
+		return jobs.Job{}, err
+	}
+	return job, nil
+}
+
+func (db *DB) ClaimJob(ctx context.Context, now time.Time, lockedUntil time.Time) (jobs.Job, error) {
+	var claimed *jobs.Job
+	err := db.update(func(data *DBStructure) error {
+		for _, job := range data.Jobs {
+			due := job.Status == jobs.StatusQueued && !job.RunAt.After(now)
+			abandoned := job.Status == jobs.StatusRunning && job.LockedUntil != nil && job.LockedUntil.Before(now)
+			if (due || abandoned) && (claimed == nil || job.RunAt.Before(claimed.RunAt)) {
+				claimed = &job
+			}
+		}
+		if claimed == nil {
+			return jobs.ErrNotExist
+		}
+
+		claimed.Status = jobs.StatusRunning
+		claimed.Attempts++
+		claimed.LockedUntil = &lockedUntil
+		claimed.UpdatedAt = now
+		data.Jobs[claimed.ID] = *claimed
+		return nil
+	})
+	if err != nil {
+		return jobs.Job{}, err
+	}
+	return *claimed, nil
+}
+
+func (db *DB) CompleteJob(ctx context.Context, id string) error {
+	return db.updateJob(id, func(job *jobs.Job) error {
+		job.Status = jobs.StatusSucceeded
+		job.LockedUntil = nil
+		job.LastError = ""
+		return nil
+	})
+}
+
+func (db *DB) FailJob(ctx context.Context, id string, status string, runAt time.Time, lastError string) error {
+	return db.updateJob(id, func(job *jobs.Job) error {
+		job.Status = status
+		job.RunAt = runAt
+		job.LockedUntil = nil
+		job.LastError = lastError
+		return nil
+	})
+}
+
+func (db *DB) GetJob(ctx context.Context, id string) (jobs.Job, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return jobs.Job{}, err
+	}
+
+	job, ok := data.Jobs[id]
+	if !ok {
+		return jobs.Job{}, jobs.ErrNotExist
+	}
+	return job, nil
+}
+
+// ListJobs returns up to limit jobs, newest first, optionally only those with a status
+func (db *DB) ListJobs(ctx context.Context, status string, limit int) ([]jobs.Job, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []jobs.Job{}
+	for _, job := range data.Jobs {
+		if status == "" || job.Status == status {
+			list = append(list, job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (db *DB) RetryJob(ctx context.Context, id string) (jobs.Job, error) {
+	var retried jobs.Job
+	err := db.updateJob(id, func(job *jobs.Job) error {
+		if job.Status == jobs.StatusRunning {
+			return jobs.ErrNotExist
+		}
+		job.Status = jobs.StatusQueued
+		job.Attempts = 0
+		job.RunAt = time.Now()
+		job.LastError = ""
+		retried = *job
+		return nil
+	})
+	if err != nil {
+		return jobs.Job{}, err
+	}
+	return retried, nil
+}
+
+// PurgeJobs removes jobs that succeeded before the cutoff. Dead jobs are kept until retried.
+func (db *DB) PurgeJobs(ctx context.Context, cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.update(func(data *DBStructure) error {
+		for id, job := range data.Jobs {
+			if job.Status == jobs.StatusSucceeded && job.UpdatedAt.Before(cutoff) {
+				delete(data.Jobs, id)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (db *DB) updateJob(id string, change func(job *jobs.Job) error) error {
+	return db.update(func(data *DBStructure) error {
+		job, ok := data.Jobs[id]
+		if !ok {
+			return jobs.ErrNotExist
+		}
+		job.UpdatedAt = time.Now()
+		if err := change(&job); err != nil {
+			return err
+		}
+		data.Jobs[id] = job
+		return nil
+	})
+}
@@ -0,0 +1,50 @@
+package database
+
+// SetTOTPSecret stores a secret for the user to confirm, replacing any unconfirmed one
+func (db *DB) SetTOTPSecret(id int, secret string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.TOTPEnabled {
+			return ErrConflict
+		}
+		user.TOTPSecret = secret
+		return nil
+	})
+}
+
+// EnableTOTP turns on two-factor authentication once the user confirmed a code at step.
+// recoveryCodes are expected to be hashed.
+func (db *DB) EnableTOTP(id int, step int64, recoveryCodes []string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.TOTPEnabled || user.TOTPSecret == "" {
+			return ErrConflict
+		}
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodes = recoveryCodes
+		return nil
+	})
+}
+
+// UseTOTPStep records the step of an accepted code, returning ErrConflict if it or a later one was already used
+func (db *DB) UseTOTPStep(id int, step int64) error {
+	return db.updateUser(id, func(user *User) error {
+		if step <= user.TOTPLastStep {
+			return ErrConflict
+		}
+		user.TOTPLastStep = step
+		return nil
+	})
+}
+
+// UseRecoveryCode removes the hashed recovery code from the user, returning ErrNotExist if they don't have it
+func (db *DB) UseRecoveryCode(id int, hashedCode string) error {
+	return db.updateUser(id, func(user *User) error {
+		for i, code := range user.RecoveryCodes {
+			if code == hashedCode {
+				user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/outbox"
+)
+
+// The json db implements outbox.Store. Events are added by the mutations that cause them, in the
+// same write, and claims are made in a single locked update so an event is only claimed once.
+
+func (db *DB) ClaimOutboxEvents(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]outbox.Event, error) {
+	claimed := []outbox.Event{}
+	err := db.update(func(data *DBStructure) error {
+		for _, event := range data.Outbox {
+			if event.DispatchedAt == nil && !event.AvailableAt.After(now) {
+				claimed = append(claimed, event)
+			}
+		}
+		if len(claimed) == 0 {
+			return errNoChange
+		}
+		sort.Slice(claimed, func(i, j int) bool {
+			return claimed[i].CreatedAt.Before(claimed[j].CreatedAt)
+		})
+		if len(claimed) > limit {
+			claimed = claimed[:limit]
+		}
+		for i := range claimed {
+			claimed[i].Attempts++
+			claimed[i].AvailableAt = lockedUntil
+			data.Outbox[claimed[i].ID] = claimed[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (db *DB) MarkOutboxDispatched(ctx context.Context, id string) error {
+	return db.updateOutboxEvent(id, func(event *outbox.Event) {
+		now := time.Now()
+		event.DispatchedAt = &now
+		event.LastError = ""
+	})
+}
+
+func (db *DB) MarkOutboxFailed(ctx context.Context, id string, retryAt time.Time, lastError string) error {
+	return db.updateOutboxEvent(id, func(event *outbox.Event) {
+		event.AvailableAt = retryAt
+		event.LastError = lastError
+	})
+}
+
+// PurgeOutbox removes events dispatched before the cutoff
+func (db *DB) PurgeOutbox(ctx context.Context, cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.update(func(data *DBStructure) error {
+		for id, event := range data.Outbox {
+			if event.DispatchedAt != nil && event.DispatchedAt.Before(cutoff) {
+				delete(data.Outbox, id)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (db *DB) updateOutboxEvent(id string, change func(event *outbox.Event)) error {
+	return db.update(func(data *DBStructure) error {
+		event, ok := data.Outbox[id]
+		if !ok {
+			return outbox.ErrNotExist
+		}
+		change(&event)
+		data.Outbox[id] = event
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"time"
+)
+
+// Passkey is a WebAuthn credential, keyed by its base64url encoded credential id
+type Passkey struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	PublicKey []byte    `json:"public_key"`
+	SignCount uint32    `json:"sign_count"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+func (db *DB) CreatePasskey(passkey Passkey) (Passkey, error) {
+	err := db.update(func(data *DBStructure) error {
+		_, exists := data.Passkeys[passkey.ID]
+		if exists {
+			return ErrConflict
+		}
+		if _, ok := data.Users[passkey.UserID]; !ok {
+			return ErrNotExist
+		}
+
+		passkey.CreatedAt = time.Now()
+		data.Passkeys[passkey.ID] = passkey
+		return nil
+	})
+	if err != nil {
+		return Passkey{}, err
+	}
+	return passkey, nil
+}
+
+func (db *DB) GetPasskey(id string) (Passkey, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	passkey, ok := data.Passkeys[id]
+	if !ok {
+		return Passkey{}, ErrNotExist
+	}
+	return passkey, nil
+}
+
+func (db *DB) GetUserPasskeys(userID int) ([]Passkey, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys := []Passkey{}
+	for _, passkey := range data.Passkeys {
+		if passkey.UserID == userID {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	return passkeys, nil
+}
+
+// UsePasskey stores the sign count from a successful login, returning ErrConflict if it did not increase
+func (db *DB) UsePasskey(id string, signCount uint32) error {
+	return db.update(func(data *DBStructure) error {
+		passkey, ok := data.Passkeys[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if (signCount != 0 || passkey.SignCount != 0) && signCount <= passkey.SignCount {
+			return ErrConflict
+		}
+
+		passkey.SignCount = signCount
+		passkey.LastUsed = time.Now()
+		data.Passkeys[id] = passkey
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/billing"
+)
+
+// Subscription is a user's Chirpy Red subscription, along with every change made to it
+type Subscription struct {
+	UserID int `json:"user_id"`
+	billing.Subscription
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	History   []SubscriptionChange `json:"history"`
+}
+
+type SubscriptionChange struct {
+	Event  string    `json:"event"`
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// GetSubscription returns the user's subscription, or ErrNotExist if they never subscribed
+func (db *DB) GetSubscription(userID int) (Subscription, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	sub, ok := data.Subscriptions[userID]
+	if !ok {
+		return Subscription{}, ErrNotExist
+	}
+	return sub, nil
+}
+
+// GetOpenSubscriptions returns the subscriptions whose period ended before the cutoff and are still open
+func (db *DB) GetOpenSubscriptions(cutoff time.Time) ([]Subscription, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	subs := []Subscription{}
+	for _, sub := range data.Subscriptions {
+		if sub.Open() && sub.CurrentPeriodEnd.Before(cutoff) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+// UpdateSubscription applies an event to the user's subscription under the db lock, so concurrent
+// events see each other's changes. A user with Chirpy Red but no subscription has a legacy one.
+// apply returns the subscription after the event and whether the user has Chirpy Red, and the
+// change is recorded in the subscription's history. It returns the subscription before and after.
+func (db *DB) UpdateSubscription(userID int, event string, apply func(current billing.Subscription) (billing.Subscription, bool, error)) (billing.Subscription, billing.Subscription, error) {
+	var before billing.Subscription
+	var saved Subscription
+	err := db.update(func(data *DBStructure) error {
+		user, ok := data.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now()
+		saved, ok = data.Subscriptions[userID]
+		if !ok {
+			saved = Subscription{UserID: userID, CreatedAt: now}
+			if user.PremiumRed {
+				saved.Subscription = billing.Legacy(now)
+			}
+		}
+		before = saved.Subscription
+
+		next, premium, err := apply(saved.Subscription)
+		if err != nil {
+			return err
+		}
+		saved.Subscription = next
+		saved.UpdatedAt = now
+		saved.History = append(saved.History, SubscriptionChange{Event: event, Status: next.Status, At: now})
+		data.Subscriptions[userID] = saved
+
+		user.PremiumRed = premium
+		data.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return billing.Subscription{}, billing.Subscription{}, err
+	}
+	return before, saved.Subscription, nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/billing"
+)
+
+func TestUpdateSubscriptionConcurrently(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	user, err := db.CreateUser("red@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	const events = 20
+	var wg sync.WaitGroup
+	for i := 0; i < events; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := db.UpdateSubscription(user.Id, billing.EventRenewed, func(current billing.Subscription) (billing.Subscription, bool, error) {
+				if current.CurrentPeriodEnd.IsZero() {
+					current.CurrentPeriodEnd = start
+				}
+				current.CurrentPeriodEnd = current.CurrentPeriodEnd.Add(24 * time.Hour)
+				return current, true, nil
+			})
+			if err != nil {
+				t.Errorf("UpdateSubscription: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sub, err := db.GetSubscription(user.Id)
+	if err != nil {
+		t.Fatalf("GetSubscription: %v", err)
+	}
+	if want := start.Add(events * 24 * time.Hour); !sub.CurrentPeriodEnd.Equal(want) {
+		t.Fatalf("CurrentPeriodEnd = %s, want %s", sub.CurrentPeriodEnd, want)
+	}
+	if len(sub.History) != events {
+		t.Fatalf("history has %d changes, want %d", len(sub.History), events)
+	}
+}
+
+func TestUpdateSubscriptionSeedsLegacy(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	user, err := db.CreateUser("legacy@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	err = db.updateUser(user.Id, func(user *User) error {
+		user.PremiumRed = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateUser: %v", err)
+	}
+
+	before, _, err := db.UpdateSubscription(user.Id, billing.EventDowngraded, func(current billing.Subscription) (billing.Subscription, bool, error) {
+		current.Status = billing.StatusCanceled
+		return current, false, nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateSubscription: %v", err)
+	}
+	if before.Status != billing.StatusActive {
+		t.Fatalf("subscription before = %+v, want a legacy active one", before)
+	}
+	updated, err := db.GetUser(user.Id)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if updated.PremiumRed {
+		t.Fatal("user still has Chirpy Red")
+	}
+}
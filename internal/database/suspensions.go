@@ -0,0 +1,69 @@
+package database
+
+import "time"
+
+// Kinds of suspension, a ban is meant to be permanent but may still be given an expiry
+const (
+	SuspensionSuspended = "suspended"
+	SuspensionBanned    = "banned"
+)
+
+type Suspension struct {
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+	// ExpiresAt is nil for a suspension that lasts until it is lifted
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	By        int        `json:"by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Active reports whether the suspension still applies at the given time
+func (s *Suspension) Active(now time.Time) bool {
+	return s != nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
+}
+
+// SuspendUser suspends or bans the user. Its token version is bumped so every access token issued
+// before now is rejected, and all of its refresh tokens are revoked.
+func (db *DB) SuspendUser(id int, suspension Suspension) (User, error) {
+	var user User
+	err := db.update(func(data *DBStructure) error {
+		var ok bool
+		user, ok = data.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		user.Suspension = &suspension
+		user.TokenVersion++
+		data.Users[id] = user
+		now := time.Now()
+		for key, token := range data.Tokens {
+			if token.UserID == id && token.Exp.After(now) {
+				token.Exp = now
+				data.Tokens[key] = token
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// LiftSuspension removes the user's suspension, returning ErrNotExist if they have none
+func (db *DB) LiftSuspension(id int) (User, error) {
+	var lifted User
+	err := db.updateUser(id, func(user *User) error {
+		if user.Suspension == nil {
+			return ErrNotExist
+		}
+		user.Suspension = nil
+		lifted = *user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return lifted, nil
+}
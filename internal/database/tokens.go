@@ -3,17 +3,22 @@ package database
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"sort"
 	"time"
 )
 
 type Token struct {
-	UserID int       `json:"user_id"`
-	Val    string    `json:"val"`
-	Iss    time.Time `json:"iss"`
-	Exp    time.Time `json:"exp"`
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	Val       string    `json:"val"`
+	Iss       time.Time `json:"iss"`
+	Exp       time.Time `json:"exp"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	LastUsed  time.Time `json:"last_used"`
 }
 
-func (db *DB) CreateRefreshToken(userID int) (Token, error) {
+func (db *DB) CreateRefreshToken(userID int, userAgent string, ip string) (Token, error) {
 	c := 32
 	b := make([]byte, c)
 	_, err := rand.Read(b)
@@ -21,22 +26,31 @@ func (db *DB) CreateRefreshToken(userID int) (Token, error) {
 		return Token{}, err
 	}
 
-	database, err := db.loadDB()
+	// A separate public id, so sessions can be listed without exposing the token itself
+	idBytes := make([]byte, 8)
+	_, err = rand.Read(idBytes)
 	if err != nil {
 		return Token{}, err
 	}
 
 	key := hex.EncodeToString(b)
 
+	now := time.Now()
 	token := Token{
-		UserID: userID,
-		Val:    key,
-		Iss:    time.Now(),
-		Exp:    time.Now().Add(time.Hour * 1440),
+		ID:        hex.EncodeToString(idBytes),
+		UserID:    userID,
+		Val:       key,
+		Iss:       now,
+		Exp:       now.Add(time.Hour * 1440),
+		UserAgent: userAgent,
+		IP:        ip,
+		LastUsed:  now,
 	}
 
-	database.Tokens[key] = token
-	wErr := db.writeDB(database)
+	wErr := db.update(func(database *DBStructure) error {
+		database.Tokens[key] = token
+		return nil
+	})
 	if wErr != nil {
 		return Token{}, wErr
 	}
@@ -56,25 +70,153 @@ func (db *DB) FindRefreshToken(token string) (Token, error) {
 	return existing, nil
 }
 
+// TouchRefreshToken records that a refresh token was just used and from where
+func (db *DB) TouchRefreshToken(token string, ip string) error {
+	return db.update(func(database *DBStructure) error {
+		existing, ok := database.Tokens[token]
+		if !ok {
+			return ErrNotExist
+		}
+		existing.LastUsed = time.Now()
+		if ip != "" {
+			existing.IP = ip
+		}
+		database.Tokens[token] = existing
+		return nil
+	})
+}
+
 func (db *DB) RevokeRefreshToken(token string) error {
-	c := 32
-	b := make([]byte, c)
-	_, err := rand.Read(b)
+	return db.update(func(database *DBStructure) error {
+		existing, ok := database.Tokens[token]
+		if !ok {
+			return ErrNotExist
+		}
+		// Revoke by expiring token
+		existing.Exp = time.Now()
+		database.Tokens[token] = existing
+		return nil
+	})
+}
+
+// GetSessions returns the user's unexpired refresh tokens, most recently used first
+func (db *DB) GetSessions(userID int) ([]Token, error) {
+	database, err := db.loadDB()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := []Token{}
+	for _, token := range database.Tokens {
+		if token.UserID == userID && token.Exp.After(now) {
+			sessions = append(sessions, token)
+		}
 	}
 
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed.After(sessions[j].LastUsed)
+	})
+	return sessions, nil
+}
+
+// GetSession returns the user's unexpired refresh token with the given session id, or ErrNotExist
+// if it expired or was revoked
+func (db *DB) GetSession(userID int, sessionID string) (Token, error) {
 	database, err := db.loadDB()
 	if err != nil {
-		return err
+		return Token{}, err
 	}
 
-	existing, ok := database.Tokens[token]
-	if !ok {
+	now := time.Now()
+	for _, token := range database.Tokens {
+		if token.ID == sessionID && token.UserID == userID && token.Exp.After(now) {
+			return token, nil
+		}
+	}
+	return Token{}, ErrNotExist
+}
+
+// RevokeSession expires the user's refresh token with the given session id
+func (db *DB) RevokeSession(userID int, sessionID string) error {
+	return db.update(func(database *DBStructure) error {
+		now := time.Now()
+		for key, token := range database.Tokens {
+			if token.ID != sessionID || token.UserID != userID || !token.Exp.After(now) {
+				continue
+			}
+			token.Exp = now
+			database.Tokens[key] = token
+			return nil
+		}
 		return ErrNotExist
+	})
+}
+
+// RevokeAllRefreshTokens expires every active refresh token of the user and returns how many were revoked
+func (db *DB) RevokeAllRefreshTokens(userID int) (int, error) {
+	return db.RevokeOtherRefreshTokens(userID, "")
+}
+
+// RevokeOtherRefreshTokens expires the user's active refresh tokens except the session to keep
+func (db *DB) RevokeOtherRefreshTokens(userID int, keepSessionID string) (int, error) {
+	count := 0
+	err := db.update(func(database *DBStructure) error {
+		now := time.Now()
+		for key, token := range database.Tokens {
+			if token.UserID == userID && token.Exp.After(now) && (keepSessionID == "" || token.ID != keepSessionID) {
+				token.Exp = now
+				database.Tokens[key] = token
+				count++
+			}
+		}
+		if count == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	// Revoke by expiring token
-	existing.Exp = time.Now()
-	database.Tokens[token] = existing
-	return db.writeDB(database)
+	return count, nil
+}
+
+// PurgeExpiredTokens permanently removes refresh tokens that expired or were revoked before the cutoff
+func (db *DB) PurgeExpiredTokens(cutoff time.Time) (int, error) {
+	count := 0
+	err := db.update(func(database *DBStructure) error {
+		for key, token := range database.Tokens {
+			if token.Exp.Before(cutoff) {
+				delete(database.Tokens, key)
+				count++
+			}
+		}
+		for id, exp := range database.UsedTokens {
+			if exp.Before(cutoff) {
+				delete(database.UsedTokens, id)
+				count++
+			}
+		}
+		if count == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ConsumeTokenID marks a single-use token as used, returning ErrConflict if it already was.
+// The id is kept until exp, after which the token is rejected for being expired anyway.
+func (db *DB) ConsumeTokenID(id string, exp time.Time) error {
+	return db.update(func(database *DBStructure) error {
+		_, used := database.UsedTokens[id]
+		if used {
+			return ErrConflict
+		}
+		database.UsedTokens[id] = exp
+		return nil
+	})
 }
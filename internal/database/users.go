@@ -1,45 +1,132 @@
 package database
 
 import (
+	"encoding/json"
+	"strings"
+	"time"
+
 	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/mailer"
 )
 
 type User struct {
-	Id         int    `json:"id"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	PremiumRed bool   `json:"is_chirpy_red"`
+	Id            int    `json:"id"`
+	Email         string `json:"email"`
+	Password      string `json:"password"`
+	PremiumRed    bool   `json:"is_chirpy_red"`
+	EmailVerified bool   `json:"is_email_verified"`
+	Role          string `json:"role"`
+	// Two-factor authentication, the secret is pending until TOTPEnabled
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	TOTPEnabled   bool     `json:"totp_enabled"`
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// Set while the user's requested deletion is in its grace period
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	// TokenVersion is embedded in access tokens, bumping it invalidates every one already issued
+	TokenVersion int         `json:"token_version"`
+	Suspension   *Suspension `json:"suspension,omitempty"`
+	// PostgresID is the id of the postgres user that authors the user's chirps, once linked
+	PostgresID string `json:"postgres_id,omitempty"`
+}
+
+// UnmarshalJSON treats users stored before email verification existed as verified
+func (u *User) UnmarshalJSON(data []byte) error {
+	type storedUser User
+	stored := struct {
+		storedUser
+		EmailVerified *bool `json:"is_email_verified"`
+	}{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*u = User(stored.storedUser)
+	u.EmailVerified = stored.EmailVerified == nil || *stored.EmailVerified
+	return nil
 }
 
 func (db *DB) CreateUser(email string, password string) (User, error) {
+	if mailer.ValidateAddress(email) != nil {
+		return User{}, ErrInvalidEmail
+	}
+
+	hashPassword, err := auth.CreatePasswordHash(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err = db.update(func(data *DBStructure) error {
+		existing := findUserByEmail(email, data.Users)
+		if existing != nil {
+			return ErrConflict
+		}
+
+		id := nextID(data.Users)
+		user = User{
+			Id:       id,
+			Email:    email,
+			Password: hashPassword,
+			Role:     auth.RoleUser,
+		}
+		data.Users[id] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (db *DB) GetUser(id int) (User, error) {
 	data, err := db.loadDB()
 	if err != nil {
 		return User{}, err
 	}
 
-	existing := findUserByEmail(email, data.Users)
-	if existing != nil {
-		return User{}, ErrConflict
+	user, ok := data.Users[id]
+	if !ok {
+		return User{}, ErrNotExist
 	}
+	return user, nil
+}
 
-	hashPassword, err := auth.CreatePasswordHash(password)
+func (db *DB) GetUserByEmail(email string) (User, error) {
+	data, err := db.loadDB()
 	if err != nil {
 		return User{}, err
 	}
 
-	id := len(data.Users) + 1
-	user := User{
-		Id:       id,
-		Email:    email,
-		Password: hashPassword,
+	existing := findUserByEmail(email, data.Users)
+	if existing == nil {
+		return User{}, ErrNotExist
 	}
-	data.Users[id] = user
-	wErr := db.writeDB(data)
-	if wErr != nil {
+	return *existing, nil
+}
+
+// GetUserByEmailFold returns the user with the email ignoring case, preferring an exact match.
+// Of several that differ only in case, the oldest is returned.
+func (db *DB) GetUserByEmailFold(email string) (User, error) {
+	data, err := db.loadDB()
+	if err != nil {
 		return User{}, err
 	}
 
-	return user, nil
+	var existing *User
+	for _, dbUser := range data.Users {
+		if dbUser.Email == email {
+			return dbUser, nil
+		}
+		if strings.EqualFold(dbUser.Email, email) && (existing == nil || dbUser.Id < existing.Id) {
+			found := dbUser
+			existing = &found
+		}
+	}
+	if existing == nil {
+		return User{}, ErrNotExist
+	}
+	return *existing, nil
 }
 
 func findUserByEmail(email string, users map[int]User) *User {
@@ -53,50 +140,196 @@ func findUserByEmail(email string, users map[int]User) *User {
 	return existing
 }
 
-func (db *DB) UpdateUser(id int, email string, password string) error {
-	data, err := db.loadDB()
+// UserPatch holds the fields of a partial update, nil fields are left unchanged
+type UserPatch struct {
+	Email    *string
+	Password *string
+}
+
+// PatchUser applies the fields that are set. Changing the email marks it unverified again, and
+// changing the password bumps the token version so access tokens issued before stop working.
+func (db *DB) PatchUser(id int, patch UserPatch) (User, error) {
+	hashPassword := ""
+	if patch.Password != nil {
+		var hashErr error
+		hashPassword, hashErr = auth.CreatePasswordHash(*patch.Password)
+		if hashErr != nil {
+			return User{}, hashErr
+		}
+	}
+
+	var user User
+	err := db.update(func(data *DBStructure) error {
+		var ok bool
+		user, ok = data.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		if patch.Email != nil && *patch.Email != user.Email {
+			if mailer.ValidateAddress(*patch.Email) != nil {
+				return ErrInvalidEmail
+			}
+			if findUserByEmail(*patch.Email, data.Users) != nil {
+				return ErrConflict
+			}
+			user.Email = *patch.Email
+			user.EmailVerified = false
+		}
+		if patch.Password != nil {
+			user.Password = hashPassword
+			user.TokenVersion++
+		}
+
+		data.Users[id] = user
+		return nil
+	})
 	if err != nil {
-		return err
+		return User{}, err
 	}
+	return user, nil
+}
 
-	_, ok := data.Users[id]
-	if !ok {
-		return ErrNotExist
+// CheckPassword verifies the user's current password, returning ErrUnauthorized if it is wrong
+func (db *DB) CheckPassword(id int, password string) error {
+	user, err := db.GetUser(id)
+	if err != nil {
+		return err
 	}
+	if auth.VerifyPasswordHash(user.Password, password) != nil {
+		return ErrUnauthorized
+	}
+	return nil
+}
 
+// UpdateUserPassword replaces the user's password and bumps their token version, leaving the rest
+// of the user unchanged
+func (db *DB) UpdateUserPassword(id int, password string) error {
 	hashPassword, hashErr := auth.CreatePasswordHash(password)
 	if hashErr != nil {
 		return hashErr
 	}
 
-	data.Users[id] = User{
-		Id:       id,
-		Email:    email,
-		Password: hashPassword,
+	return db.updateUser(id, func(user *User) error {
+		user.Password = hashPassword
+		user.TokenVersion++
+		return nil
+	})
+}
+
+// ClaimUnverifiedUser hands an account whose email was never verified to the owner of the email,
+// once they have proven it another way. Whoever registered the account may not own the email, so its
+// password is replaced and its sessions, second factor, passkeys, api keys and webhook endpoints are
+// removed before the email is marked verified. It returns ErrConflict if the account was verified or
+// its email changed in the meantime.
+func (db *DB) ClaimUnverifiedUser(id int, email string, password string) (User, error) {
+	hashPassword, hashErr := auth.CreatePasswordHash(password)
+	if hashErr != nil {
+		return User{}, hashErr
 	}
 
-	return db.writeDB(data)
-}
+	var user User
+	err := db.update(func(data *DBStructure) error {
+		var ok bool
+		user, ok = data.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if user.EmailVerified || user.Email != email {
+			return ErrConflict
+		}
 
-func (db *DB) UpdateUserPremiumRed(id int, isPremiumRed bool) error {
-	data, err := db.loadDB()
+		user.Password = hashPassword
+		user.EmailVerified = true
+		user.TOTPSecret = ""
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+		user.TokenVersion++
+		data.Users[id] = user
+
+		now := time.Now()
+		for key, token := range data.Tokens {
+			if token.UserID == id && token.Exp.After(now) {
+				token.Exp = now
+				data.Tokens[key] = token
+			}
+		}
+		for key, passkey := range data.Passkeys {
+			if passkey.UserID == id {
+				delete(data.Passkeys, key)
+			}
+		}
+		for key, apiKey := range data.APIKeys {
+			if apiKey.UserID == id {
+				delete(data.APIKeys, key)
+			}
+		}
+		for key, endpoint := range data.WebhookEndpoints {
+			if endpoint.UserID == id {
+				delete(data.WebhookEndpoints, key)
+			}
+		}
+		for key, delivery := range data.WebhookDeliveries {
+			if _, ok := data.WebhookEndpoints[delivery.EndpointID]; !ok {
+				delete(data.WebhookDeliveries, key)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return User{}, err
 	}
+	return user, nil
+}
 
-	user, ok := data.Users[id]
-	if !ok {
-		return ErrNotExist
-	}
+// UpdateUserRole changes the user's role and bumps their token version, so access tokens
+// claiming the old role stop working
+func (db *DB) UpdateUserRole(id int, role string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.Role == role {
+			return errNoChange
+		}
+		user.Role = role
+		user.TokenVersion++
+		return nil
+	})
+}
+
+// PromoteFirstAdmin makes the user with the email an admin, returning ErrConflict if there already is one
+func (db *DB) PromoteFirstAdmin(email string) (User, error) {
+	var admin User
+	err := db.update(func(data *DBStructure) error {
+		for _, user := range data.Users {
+			if user.Role == auth.RoleAdmin {
+				return ErrConflict
+			}
+		}
+		existing := findUserByEmail(email, data.Users)
+		if existing == nil {
+			return ErrNotExist
+		}
 
-	data.Users[id] = User{
-		Id:         id,
-		Email:      user.Email,
-		Password:   user.Password,
-		PremiumRed: isPremiumRed,
+		existing.Role = auth.RoleAdmin
+		data.Users[existing.Id] = *existing
+		admin = *existing
+		return nil
+	})
+	if err != nil {
+		return User{}, err
 	}
+	return admin, nil
+}
 
-	return db.writeDB(data)
+// VerifyUserEmail marks the user's email as verified, if it is still the email the verification was sent to
+func (db *DB) VerifyUserEmail(id int, email string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.Email != email {
+			return ErrNotExist
+		}
+		user.EmailVerified = true
+		return nil
+	})
 }
 
 func (db *DB) Login(email string, password string) (User, error) {
@@ -105,9 +338,11 @@ func (db *DB) Login(email string, password string) (User, error) {
 		return User{}, err
 	}
 
+	// Unknown emails fail the same way as wrong passwords, so accounts can't be discovered
 	existing := findUserByEmail(email, data.Users)
 	if existing == nil {
-		return User{}, ErrConflict
+		auth.CompareDummyHash(password)
+		return User{}, ErrUnauthorized
 	}
 
 	verified := auth.VerifyPasswordHash(existing.Password, password)
@@ -115,5 +350,162 @@ func (db *DB) Login(email string, password string) (User, error) {
 		return User{}, ErrUnauthorized
 	}
 
+	// Upgrade hashes from an old algorithm or weaker parameters while the password is at hand
+	if auth.NeedsRehash(existing.Password) {
+		hashPassword, hashErr := auth.CreatePasswordHash(password)
+		if hashErr == nil {
+			oldHash := existing.Password
+			existing.Password = hashPassword
+			uErr := db.updateUser(existing.Id, func(user *User) error {
+				// The password was changed since it was checked
+				if user.Password != oldHash {
+					return errNoChange
+				}
+				user.Password = hashPassword
+				return nil
+			})
+			if uErr != nil {
+				return User{}, uErr
+			}
+		}
+	}
+
 	return *existing, nil
 }
+
+// LinkPostgresUser records the postgres user that authors the user's chirps, returning ErrConflict
+// if the user is already linked to another one
+func (db *DB) LinkPostgresUser(id int, postgresID string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.PostgresID != "" && user.PostgresID != postgresID {
+			return ErrConflict
+		}
+		user.PostgresID = postgresID
+		return nil
+	})
+}
+
+// UnlinkPostgresUser forgets the user's postgres user, if they are still linked to it, so another
+// can be linked after it was deleted
+func (db *DB) UnlinkPostgresUser(id int, postgresID string) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.PostgresID != postgresID {
+			return errNoChange
+		}
+		user.PostgresID = ""
+		return nil
+	})
+}
+
+// UnlinkPostgresUsers forgets the postgres user of every user, for when all postgres users are deleted
+func (db *DB) UnlinkPostgresUsers() error {
+	return db.update(func(data *DBStructure) error {
+		changed := false
+		for id, user := range data.Users {
+			if user.PostgresID == "" {
+				continue
+			}
+			user.PostgresID = ""
+			data.Users[id] = user
+			changed = true
+		}
+		if !changed {
+			return errNoChange
+		}
+		return nil
+	})
+}
+
+// ScheduleUserDeletion marks the user to be deleted at the given time, unless they cancel before then
+func (db *DB) ScheduleUserDeletion(id int, at time.Time) error {
+	return db.updateUser(id, func(user *User) error {
+		user.DeletionScheduledAt = &at
+		return nil
+	})
+}
+
+func (db *DB) CancelUserDeletion(id int) error {
+	return db.updateUser(id, func(user *User) error {
+		if user.DeletionScheduledAt == nil {
+			return ErrNotExist
+		}
+		user.DeletionScheduledAt = nil
+		return nil
+	})
+}
+
+// GetUsersDueForDeletion returns users whose deletion grace period ended before now
+func (db *DB) GetUsersDueForDeletion(now time.Time) ([]User, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	users := []User{}
+	for _, user := range data.Users {
+		if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(now) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// DeleteUser permanently removes the user along with their chirps, refresh tokens, passkeys, api keys and export
+func (db *DB) DeleteUser(id int) error {
+	return db.update(func(data *DBStructure) error {
+		if _, ok := data.Users[id]; !ok {
+			return ErrNotExist
+		}
+		delete(data.Users, id)
+
+		for key, chirp := range data.Chirps {
+			if chirp.AuthorID == id {
+				delete(data.Chirps, key)
+			}
+		}
+		for key, token := range data.Tokens {
+			if token.UserID == id {
+				delete(data.Tokens, key)
+			}
+		}
+		for key, passkey := range data.Passkeys {
+			if passkey.UserID == id {
+				delete(data.Passkeys, key)
+			}
+		}
+		for key, apiKey := range data.APIKeys {
+			if apiKey.UserID == id {
+				delete(data.APIKeys, key)
+			}
+		}
+		delete(data.Exports, id)
+		delete(data.Subscriptions, id)
+		for key, endpoint := range data.WebhookEndpoints {
+			if endpoint.UserID == id {
+				delete(data.WebhookEndpoints, key)
+			}
+		}
+		for key, delivery := range data.WebhookDeliveries {
+			if _, ok := data.WebhookEndpoints[delivery.EndpointID]; !ok {
+				delete(data.WebhookDeliveries, key)
+			}
+		}
+
+		return nil
+	})
+}
+
+// updateUser applies change to the user inside a locked update, returning ErrNotExist if there is no such user
+func (db *DB) updateUser(id int, change func(user *User) error) error {
+	return db.update(func(data *DBStructure) error {
+		user, ok := data.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if err := change(&user); err != nil {
+			return err
+		}
+		data.Users[id] = user
+		return nil
+	})
+}
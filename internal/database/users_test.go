@@ -0,0 +1,63 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestClaimUnverifiedUser(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	user, err := db.CreateUser("Victim@example.com", "attacker's password")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	_, err = db.CreateRefreshToken(user.Id, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	_, err = db.CreateAPIKey(APIKey{ID: "key", UserID: user.Id}, 1)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	err = db.SetTOTPSecret(user.Id, "secret")
+	if err != nil {
+		t.Fatalf("SetTOTPSecret: %v", err)
+	}
+	err = db.EnableTOTP(user.Id, 1, []string{"code"})
+	if err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+
+	found, err := db.GetUserByEmailFold("victim@EXAMPLE.com")
+	if err != nil || found.Id != user.Id {
+		t.Fatalf("GetUserByEmailFold = %+v, %v, want user %d", found, err, user.Id)
+	}
+
+	claimed, err := db.ClaimUnverifiedUser(user.Id, user.Email, "owner's password")
+	if err != nil {
+		t.Fatalf("ClaimUnverifiedUser: %v", err)
+	}
+	if !claimed.EmailVerified || claimed.TOTPEnabled || claimed.TokenVersion != user.TokenVersion+1 {
+		t.Fatalf("claimed user = %+v", claimed)
+	}
+	if !errors.Is(db.CheckPassword(user.Id, "attacker's password"), ErrUnauthorized) {
+		t.Fatal("old password still works")
+	}
+	sessions, err := db.GetSessions(user.Id)
+	if err != nil || len(sessions) != 0 {
+		t.Fatalf("GetSessions = %v, %v, want none", sessions, err)
+	}
+	keys, err := db.GetUserAPIKeys(user.Id)
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("GetUserAPIKeys = %v, %v, want none", keys, err)
+	}
+
+	_, err = db.ClaimUnverifiedUser(user.Id, user.Email, "another password")
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("claiming a verified user error = %v, want %v", err, ErrConflict)
+	}
+}
@@ -5,6 +5,8 @@
 package v2
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,9 +20,56 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
-type User struct {
+type Job struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Type        string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int32
+	MaxAttempts int32
+	RunAt       time.Time
+	LockedUntil sql.NullTime
+	LastError   string
+}
+
+type OutboxEvent struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	Type         string
+	Subject      string
+	Payload      json.RawMessage
+	Attempts     int32
+	AvailableAt  time.Time
+	LastError    string
+	DispatchedAt sql.NullTime
+}
+
+type Subscription struct {
+	UserID           uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Plan             string
+	Status           string
+	CurrentPeriodEnd time.Time
+	GraceUntil       sql.NullTime
+	LastEventAt      sql.NullTime
+}
+
+type SubscriptionEvent struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Email     string
+	UserID    uuid.UUID
+	Event     string
+	Status    string
+}
+
+type User struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Email         string
+	EmailVerified bool
+	IsChirpyRed   bool
 }
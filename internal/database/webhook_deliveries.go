@@ -0,0 +1,278 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/auth"
+)
+
+// Statuses of an outgoing webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint is a url a user registered to receive events. Endpoints of an admin receive
+// events about every user, others only about their owner.
+type WebhookEndpoint struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event queued for one endpoint, along with a log of every attempt to send it
+type WebhookDelivery struct {
+	ID            string            `json:"id"`
+	EndpointID    string            `json:"endpoint_id"`
+	EventID       string            `json:"event_id"`
+	Event         string            `json:"event"`
+	Payload       string            `json:"payload"`
+	Status        string            `json:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	// Set on a manual redelivery, the id of the delivery it copied
+	RedeliveryOf string `json:"redelivery_of,omitempty"`
+}
+
+type DeliveryAttempt struct {
+	At         time.Time     `json:"at"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+func (db *DB) CreateWebhookEndpoint(endpoint WebhookEndpoint) (WebhookEndpoint, error) {
+	id, err := randomID()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	err = db.update(func(data *DBStructure) error {
+		if _, ok := data.Users[endpoint.UserID]; !ok {
+			return ErrNotExist
+		}
+		endpoint.ID = id
+		endpoint.CreatedAt = time.Now()
+		data.WebhookEndpoints[endpoint.ID] = endpoint
+		return nil
+	})
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	return endpoint, nil
+}
+
+// GetWebhookEndpoint returns the user's endpoint, or ErrNotExist if it belongs to someone else
+func (db *DB) GetWebhookEndpoint(userID int, id string) (WebhookEndpoint, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	endpoint, ok := data.WebhookEndpoints[id]
+	if !ok || endpoint.UserID != userID {
+		return WebhookEndpoint{}, ErrNotExist
+	}
+	return endpoint, nil
+}
+
+// GetUserWebhookEndpoints returns the user's endpoints, oldest first
+func (db *DB) GetUserWebhookEndpoints(userID int) ([]WebhookEndpoint, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []WebhookEndpoint{}
+	for _, endpoint := range data.WebhookEndpoints {
+		if endpoint.UserID == userID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+	})
+	return endpoints, nil
+}
+
+// DeleteWebhookEndpoint removes the user's endpoint along with its deliveries
+func (db *DB) DeleteWebhookEndpoint(userID int, id string) error {
+	return db.update(func(data *DBStructure) error {
+		endpoint, ok := data.WebhookEndpoints[id]
+		if !ok || endpoint.UserID != userID {
+			return ErrNotExist
+		}
+		delete(data.WebhookEndpoints, id)
+		for key, delivery := range data.WebhookDeliveries {
+			if delivery.EndpointID == id {
+				delete(data.WebhookDeliveries, key)
+			}
+		}
+		return nil
+	})
+}
+
+// EnqueueWebhookEvent queues a delivery of the event to every endpoint subscribed to it.
+// subject is the id of the user the event is about. It returns how many deliveries were queued.
+func (db *DB) EnqueueWebhookEvent(eventID string, event string, subject string, payload string) (int, error) {
+	count := 0
+	err := db.update(func(data *DBStructure) error {
+		// An event queued again, such as one dispatched twice from the outbox, keeps its deliveries
+		queued := map[string]bool{}
+		for _, delivery := range data.WebhookDeliveries {
+			if delivery.EventID == eventID && delivery.RedeliveryOf == "" {
+				queued[delivery.EndpointID] = true
+			}
+		}
+
+		now := time.Now()
+		for _, endpoint := range data.WebhookEndpoints {
+			if !slices.Contains(endpoint.Events, event) || queued[endpoint.ID] {
+				continue
+			}
+			// The owner's current role decides, so an endpoint stops receiving everyone's
+			// events as soon as its owner is no longer an admin
+			owner, ok := data.Users[endpoint.UserID]
+			if !ok || (owner.Role != auth.RoleAdmin && subject != strconv.Itoa(endpoint.UserID)) {
+				continue
+			}
+			id, err := randomID()
+			if err != nil {
+				return err
+			}
+			data.WebhookDeliveries[id] = WebhookDelivery{
+				ID:            id,
+				EndpointID:    endpoint.ID,
+				EventID:       eventID,
+				Event:         event,
+				Payload:       payload,
+				Status:        DeliveryPending,
+				Attempts:      []DeliveryAttempt{},
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			}
+			count++
+		}
+		if count == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries that are due, oldest first,
+// with the endpoint each is for
+func (db *DB) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, map[string]WebhookEndpoint, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	due := []WebhookDelivery{}
+	for _, delivery := range data.WebhookDeliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	endpoints := map[string]WebhookEndpoint{}
+	for _, delivery := range due {
+		endpoints[delivery.EndpointID] = data.WebhookEndpoints[delivery.EndpointID]
+	}
+	return due, endpoints, nil
+}
+
+// RecordDeliveryAttempt logs an attempt and sets the delivery's status. A pending delivery is
+// retried at nextAttempt.
+func (db *DB) RecordDeliveryAttempt(id string, attempt DeliveryAttempt, status string, nextAttempt time.Time) error {
+	return db.update(func(data *DBStructure) error {
+		delivery, ok := data.WebhookDeliveries[id]
+		if !ok {
+			// The endpoint was deleted while the delivery was being sent
+			return ErrNotExist
+		}
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Status = status
+		delivery.NextAttemptAt = nextAttempt
+		data.WebhookDeliveries[id] = delivery
+		return nil
+	})
+}
+
+// GetWebhookDeliveries returns the endpoint's deliveries, newest first
+func (db *DB) GetWebhookDeliveries(endpointID string) ([]WebhookDelivery, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range data.WebhookDeliveries {
+		if delivery.EndpointID == endpointID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues a new delivery with the same payload as an earlier one of the endpoint
+func (db *DB) RedeliverWebhook(endpointID string, deliveryID string) (WebhookDelivery, error) {
+	id, err := randomID()
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	var delivery WebhookDelivery
+	err = db.update(func(data *DBStructure) error {
+		original, ok := data.WebhookDeliveries[deliveryID]
+		if !ok || original.EndpointID != endpointID {
+			return ErrNotExist
+		}
+
+		now := time.Now()
+		delivery = original
+		delivery.ID = id
+		delivery.Status = DeliveryPending
+		delivery.Attempts = []DeliveryAttempt{}
+		delivery.NextAttemptAt = now
+		delivery.CreatedAt = now
+		delivery.RedeliveryOf = original.ID
+		data.WebhookDeliveries[id] = delivery
+		return nil
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+func randomID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
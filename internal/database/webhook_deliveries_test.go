@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethpalser/chirpy/internal/auth"
+)
+
+func TestEnqueueWebhookEvent(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	owner, err := db.CreateUser("owner@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	other, err := db.CreateUser("other@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	admin, err := db.CreateUser("admin@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	err = db.UpdateUserRole(admin.Id, auth.RoleAdmin)
+	if err != nil {
+		t.Fatalf("UpdateUserRole: %v", err)
+	}
+	for _, user := range []User{owner, admin} {
+		_, err = db.CreateWebhookEndpoint(WebhookEndpoint{
+			UserID: user.Id,
+			URL:    "https://example.com/hook",
+			Events: []string{"chirp.created"},
+		})
+		if err != nil {
+			t.Fatalf("CreateWebhookEndpoint: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		eventID string
+		event   string
+		subject string
+		want    int
+	}{
+		{name: "about the owner", eventID: "1", event: "chirp.created", subject: fmt.Sprint(owner.Id), want: 2},
+		{name: "about another user", eventID: "2", event: "chirp.created", subject: fmt.Sprint(other.Id), want: 1},
+		{name: "subject that is not a user id", eventID: "3", event: "chirp.created", subject: "0b7c6f4e-3d9a-4a8e-9f1e-2c5d8b7a6e4f", want: 1},
+		{name: "event not subscribed to", eventID: "4", event: "chirp.deleted", subject: fmt.Sprint(owner.Id), want: 0},
+		{name: "already queued", eventID: "1", event: "chirp.created", subject: fmt.Sprint(owner.Id), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.EnqueueWebhookEvent(tt.eventID, tt.event, tt.subject, "{}")
+			if err != nil {
+				t.Fatalf("EnqueueWebhookEvent: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("EnqueueWebhookEvent queued %d deliveries, want %d", got, tt.want)
+			}
+		})
+	}
+}
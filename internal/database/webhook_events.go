@@ -0,0 +1,129 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Outcomes of processing a webhook event
+const (
+	WebhookEventReceived  = "received"
+	WebhookEventProcessed = "processed"
+	WebhookEventIgnored   = "ignored"
+	WebhookEventFailed    = "failed"
+)
+
+// WebhookEvent is a received webhook delivery, kept so a retried delivery is not applied twice
+// and so it can be inspected or replayed. Payload is the raw body exactly as it was received.
+type WebhookEvent struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source"`
+	Event      string    `json:"event"`
+	Payload    string    `json:"payload"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Attempts   int       `json:"attempts"`
+	ReceivedAt time.Time `json:"received_at"`
+	// when a delivery last started processing the event
+	ClaimedAt   time.Time  `json:"claimed_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+// RecordWebhookEvent stores a newly received event and claims it for processing. If an event
+// with the same source and id was already recorded, that event is returned with ErrConflict,
+// unless it failed or was claimed longer than staleAfter ago without finishing. Such an event is
+// claimed again and returned without an error, so exactly one delivery retries it.
+func (db *DB) RecordWebhookEvent(event WebhookEvent, staleAfter time.Duration) (WebhookEvent, error) {
+	now := time.Now()
+	key := event.Source + ":" + event.ID
+	var conflict bool
+	err := db.update(func(data *DBStructure) error {
+		existing, ok := data.WebhookEvents[key]
+		if !ok {
+			event.ReceivedAt = now
+			event.ClaimedAt = now
+			data.WebhookEvents[key] = event
+			return nil
+		}
+
+		claimedAt := existing.ClaimedAt
+		if claimedAt.IsZero() {
+			claimedAt = existing.ReceivedAt
+		}
+		stale := existing.Status == WebhookEventReceived && now.Sub(claimedAt) > staleAfter
+		if existing.Status != WebhookEventFailed && !stale {
+			event = existing
+			conflict = true
+			return errNoChange
+		}
+		existing.Status = WebhookEventReceived
+		existing.ClaimedAt = now
+		data.WebhookEvents[key] = existing
+		event = existing
+		return nil
+	})
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	if conflict {
+		return event, ErrConflict
+	}
+	return event, nil
+}
+
+func (db *DB) GetWebhookEvent(source string, id string) (WebhookEvent, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	event, ok := data.WebhookEvents[source+":"+id]
+	if !ok {
+		return WebhookEvent{}, ErrNotExist
+	}
+	return event, nil
+}
+
+// GetWebhookEvents returns the source's events, newest first, optionally only those with a status
+func (db *DB) GetWebhookEvents(source string, status string) ([]WebhookEvent, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []WebhookEvent{}
+	for _, event := range data.WebhookEvents {
+		if event.Source == source && (status == "" || event.Status == status) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ReceivedAt.After(events[j].ReceivedAt)
+	})
+	return events, nil
+}
+
+// FinishWebhookEvent records the outcome of an attempt at processing the event
+func (db *DB) FinishWebhookEvent(source string, id string, status string, errMsg string) (WebhookEvent, error) {
+	key := source + ":" + id
+	event := WebhookEvent{}
+	err := db.update(func(data *DBStructure) error {
+		var ok bool
+		event, ok = data.WebhookEvents[key]
+		if !ok {
+			return ErrNotExist
+		}
+
+		now := time.Now()
+		event.Status = status
+		event.Error = errMsg
+		event.Attempts++
+		event.ProcessedAt = &now
+		data.WebhookEvents[key] = event
+		return nil
+	})
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	return event, nil
+}
@@ -0,0 +1,128 @@
+// Package entitlements maps a user's plan to what they may do, such as how long their chirps may be
+package entitlements
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/chirptext"
+)
+
+// PlanFree is the plan of every user without a subscription
+const PlanFree = "free"
+
+// Feature flags a plan can turn on
+const (
+	FeatureChirpEditing = "chirp_editing"
+	FeatureChirpMedia   = "chirp_media"
+)
+
+type Limits struct {
+	MaxChirpLength int `json:"max_chirp_length"`
+	// What MaxChirpLength counts, graphemes when empty
+	ChirpLengthUnit string `json:"chirp_length_unit,omitempty"`
+	// How long after posting a chirp may be edited
+	EditWindow    Duration `json:"edit_window"`
+	MediaPerChirp int      `json:"media_per_chirp"`
+	// Active api keys a user may hold at once
+	MaxAPIKeys int             `json:"max_api_keys"`
+	Features   map[string]bool `json:"features"`
+}
+
+func (l Limits) Has(feature string) bool {
+	return l.Features[feature]
+}
+
+// Service looks up the limits of each plan. It is safe for concurrent use once loaded.
+type Service struct {
+	plans map[string]Limits
+}
+
+// Duration is a time.Duration written as a string such as "15m" in the config file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default is used when no config file is given
+func Default() *Service {
+	return &Service{plans: map[string]Limits{
+		PlanFree: {
+			MaxChirpLength:  140,
+			ChirpLengthUnit: chirptext.UnitGraphemes,
+			MaxAPIKeys:      3,
+			Features:        map[string]bool{},
+		},
+		"red": {
+			MaxChirpLength:  280,
+			ChirpLengthUnit: chirptext.UnitGraphemes,
+			EditWindow:      Duration(time.Hour),
+			MediaPerChirp:   4,
+			MaxAPIKeys:      20,
+			Features: map[string]bool{
+				FeatureChirpEditing: true,
+				FeatureChirpMedia:   true,
+			},
+		},
+	}}
+}
+
+// Load reads the limits of each plan from a json file of the form {"plans": {"<plan>": {...}}}.
+// The file must define the free plan. Without a path the defaults are used. Each environment
+// can point ENTITLEMENTS_FILE at its own file.
+func Load(path string) (*Service, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Plans map[string]Limits `json:"plans"`
+	}{}
+	err = json.Unmarshal(file, &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entitlements file %s: %w", path, err)
+	}
+
+	if _, ok := config.Plans[PlanFree]; !ok {
+		return nil, fmt.Errorf("entitlements file %s is missing the %s plan", path, PlanFree)
+	}
+	for name, limits := range config.Plans {
+		if limits.MaxChirpLength <= 0 {
+			return nil, fmt.Errorf("plan %s in %s needs a positive max_chirp_length", name, path)
+		}
+		if !chirptext.ValidUnit(limits.ChirpLengthUnit) {
+			return nil, fmt.Errorf("plan %s in %s has an unknown chirp_length_unit %q", name, path, limits.ChirpLengthUnit)
+		}
+	}
+	return &Service{plans: config.Plans}, nil
+}
+
+// For returns the limits of the plan, falling back to the free plan for one that isn't configured
+func (s *Service) For(plan string) Limits {
+	limits, ok := s.plans[plan]
+	if !ok {
+		return s.plans[PlanFree]
+	}
+	return limits
+}
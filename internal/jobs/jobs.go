@@ -0,0 +1,192 @@
+// Package jobs runs background work from a persistent queue. Jobs survive restarts, are retried
+// with backoff when their handler fails, and are dead-lettered after too many attempts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/util"
+)
+
+// Statuses of a job. A queued job runs once RunAt has passed, a running job whose lease expired
+// was abandoned by a worker that crashed and is claimed again.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+var ErrNotExist = errors.New("job does not exist")
+
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// Store persists jobs. ClaimJob must hand each due job to only one caller, marking it running
+// with its attempts incremented, and returns ErrNotExist when no job is due.
+type Store interface {
+	EnqueueJob(ctx context.Context, job Job) (Job, error)
+	ClaimJob(ctx context.Context, now time.Time, lockedUntil time.Time) (Job, error)
+	CompleteJob(ctx context.Context, id string) error
+	// FailJob queues the job again at runAt, or dead-letters it if status is StatusDead
+	FailJob(ctx context.Context, id string, status string, runAt time.Time, lastError string) error
+	GetJob(ctx context.Context, id string) (Job, error)
+	ListJobs(ctx context.Context, status string, limit int) ([]Job, error)
+	// RetryJob queues a job that isn't running to run now, with its attempts reset
+	RetryJob(ctx context.Context, id string) (Job, error)
+	PurgeJobs(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// Handler does the work of a job. An error retries it, unless the job is out of attempts.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+type Options struct {
+	Workers      int
+	PollInterval time.Duration
+	// MaxAttempts of a job enqueued without its own
+	MaxAttempts int
+	// Timeout of a single attempt, the job's lease lasts a little longer
+	Timeout   time.Duration
+	RetryBase time.Duration
+	RetryMax  time.Duration
+}
+
+type Queue struct {
+	store    Store
+	opts     Options
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func New(store Store, opts Options) *Queue {
+	return &Queue{
+		store:    store,
+		opts:     opts,
+		handlers: map[string]Handler{},
+	}
+}
+
+// Register sets the handler of a job type. Handlers must be registered before Run.
+func (q *Queue) Register(jobType string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = handler
+}
+
+// Enqueue adds a job to run as soon as a worker is free
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload interface{}) (Job, error) {
+	return q.EnqueueAt(ctx, jobType, payload, time.Now())
+}
+
+// EnqueueAt adds a job to run once runAt has passed
+func (q *Queue) EnqueueAt(ctx context.Context, jobType string, payload interface{}, runAt time.Time) (Job, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, err
+	}
+	return q.store.EnqueueJob(ctx, Job{
+		Type:        jobType,
+		Payload:     body,
+		Status:      StatusQueued,
+		MaxAttempts: q.opts.MaxAttempts,
+		RunAt:       runAt,
+	})
+}
+
+func (q *Queue) Store() Store {
+	return q.store
+}
+
+// Run starts the workers and blocks until ctx is cancelled and every job in progress has finished
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		job, err := q.store.ClaimJob(ctx, time.Now(), time.Now().Add(q.opts.Timeout+q.opts.PollInterval))
+		if err != nil {
+			if !errors.Is(err, ErrNotExist) && ctx.Err() == nil {
+				log.Printf("Failed to claim a job: %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(q.opts.PollInterval):
+			}
+			continue
+		}
+		q.process(ctx, job)
+	}
+}
+
+// process runs the job to completion even if ctx is cancelled meanwhile, so a shutdown
+// doesn't abandon it half done
+func (q *Queue) process(ctx context.Context, job Job) {
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), q.opts.Timeout)
+	defer cancel()
+
+	err := q.execute(runCtx, job)
+	if err == nil {
+		err = q.store.CompleteJob(runCtx, job.ID)
+		if err != nil {
+			log.Printf("Failed to complete job %s: %s", job.ID, err)
+		}
+		return
+	}
+
+	status := StatusQueued
+	runAt := time.Now().Add(util.Backoff(job.Attempts, q.opts.RetryBase, q.opts.RetryMax))
+	if job.Attempts >= job.MaxAttempts {
+		status = StatusDead
+		log.Printf("Job %s (%s) is dead after %d attempts: %s", job.ID, job.Type, job.Attempts, err)
+	}
+	storeErr := q.store.FailJob(runCtx, job.ID, status, runAt, err.Error())
+	if storeErr != nil {
+		log.Printf("Failed to record failure of job %s: %s", job.ID, storeErr)
+	}
+}
+
+func (q *Queue) execute(ctx context.Context, job Job) (err error) {
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for job type %s", job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job.Payload)
+}
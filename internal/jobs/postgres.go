@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	database2 "github.com/ethpalser/chirpy/internal/database/v2"
+	"github.com/google/uuid"
+)
+
+// PostgresStore keeps jobs in the jobs table. Claims use SKIP LOCKED, so any number of
+// workers and servers can share it.
+type PostgresStore struct {
+	queries *database2.Queries
+}
+
+func NewPostgresStore(queries *database2.Queries) *PostgresStore {
+	return &PostgresStore{queries: queries}
+}
+
+func (s *PostgresStore) EnqueueJob(ctx context.Context, job Job) (Job, error) {
+	dbJob, err := s.queries.EnqueueJob(ctx, database2.EnqueueJobParams{
+		Type:        job.Type,
+		Payload:     job.Payload,
+		MaxAttempts: int32(job.MaxAttempts),
+		RunAt:       job.RunAt,
+	})
+	return fromPostgres(dbJob), err
+}
+
+func (s *PostgresStore) ClaimJob(ctx context.Context, now time.Time, lockedUntil time.Time) (Job, error) {
+	dbJob, err := s.queries.ClaimJob(ctx, database2.ClaimJobParams{
+		RunAt:       now,
+		LockedUntil: sql.NullTime{Time: lockedUntil, Valid: true},
+	})
+	if err != nil {
+		return Job{}, notExist(err)
+	}
+	return fromPostgres(dbJob), nil
+}
+
+func (s *PostgresStore) CompleteJob(ctx context.Context, id string) error {
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrNotExist
+	}
+	return s.queries.CompleteJob(ctx, jobID)
+}
+
+func (s *PostgresStore) FailJob(ctx context.Context, id string, status string, runAt time.Time, lastError string) error {
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrNotExist
+	}
+	return s.queries.FailJob(ctx, database2.FailJobParams{
+		ID:        jobID,
+		Status:    status,
+		RunAt:     runAt,
+		LastError: lastError,
+	})
+}
+
+func (s *PostgresStore) GetJob(ctx context.Context, id string) (Job, error) {
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return Job{}, ErrNotExist
+	}
+	dbJob, err := s.queries.GetJob(ctx, jobID)
+	if err != nil {
+		return Job{}, notExist(err)
+	}
+	return fromPostgres(dbJob), nil
+}
+
+func (s *PostgresStore) ListJobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	dbJobs, err := s.queries.ListJobs(ctx, database2.ListJobsParams{
+		Status:  status,
+		MaxRows: int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Job, 0, len(dbJobs))
+	for _, dbJob := range dbJobs {
+		list = append(list, fromPostgres(dbJob))
+	}
+	return list, nil
+}
+
+func (s *PostgresStore) RetryJob(ctx context.Context, id string) (Job, error) {
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return Job{}, ErrNotExist
+	}
+	dbJob, err := s.queries.RetryJob(ctx, jobID)
+	if err != nil {
+		return Job{}, notExist(err)
+	}
+	return fromPostgres(dbJob), nil
+}
+
+func (s *PostgresStore) PurgeJobs(ctx context.Context, cutoff time.Time) (int, error) {
+	removed, err := s.queries.DeleteSucceededJobs(ctx, cutoff)
+	return int(removed), err
+}
+
+func fromPostgres(dbJob database2.Job) Job {
+	job := Job{
+		ID:          dbJob.ID.String(),
+		Type:        dbJob.Type,
+		Payload:     dbJob.Payload,
+		Status:      dbJob.Status,
+		Attempts:    int(dbJob.Attempts),
+		MaxAttempts: int(dbJob.MaxAttempts),
+		RunAt:       dbJob.RunAt,
+		LastError:   dbJob.LastError,
+		CreatedAt:   dbJob.CreatedAt,
+		UpdatedAt:   dbJob.UpdatedAt,
+	}
+	if dbJob.LockedUntil.Valid {
+		job.LockedUntil = &dbJob.LockedUntil.Time
+	}
+	return job
+}
+
+func notExist(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+)
+
+var ErrInvalidAddress = errors.New("invalid email address")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain text emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ValidateAddress accepts a bare address such as "user@example.com", without a display name
+func ValidateAddress(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrInvalidAddress
+	}
+	return nil
+}
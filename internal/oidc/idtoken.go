@@ -0,0 +1,157 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+var ErrUnknownKey = errors.New("id token signed with an unknown key")
+
+// IDTokenClaims are the claims chirpy reads from a verified id token
+type IDTokenClaims struct {
+	Nonce           string `json:"nonce"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	AuthorizedParty string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// VerifyIDToken checks the id token's signature against the provider's JWKS, its issuer, audience and expiry,
+// and that it carries the nonce sent with the authorization request
+func (p *Provider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*IDTokenClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}
+	claims := &IDTokenClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidIDToken, err)
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+// key returns the public key with the id, fetching the JWKS again if it is unknown in case the provider rotated keys
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	err := p.refreshKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	type jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	set := jwks{}
+	err = p.getJSON(ctx, meta.JWKSURI, &set)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	b64 := base64.RawURLEncoding
+	switch jwk.Kty {
+	case "RSA":
+		n, errN := b64.DecodeString(jwk.N)
+		e, errE := b64.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			return nil, ErrUnknownKey
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		x, errX := b64.DecodeString(jwk.X)
+		y, errY := b64.DecodeString(jwk.Y)
+		if errX != nil || errY != nil || jwk.Crv != "P-256" {
+			return nil, ErrUnknownKey
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrUnknownKey
+		}
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
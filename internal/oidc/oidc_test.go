@@ -0,0 +1,355 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "chirpy"
+const testNonce = "the-nonce"
+
+// mockProvider is an identity provider serving discovery, a JWKS and a token endpoint that checks PKCE
+type mockProvider struct {
+	server  *httptest.Server
+	issuer  string
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	mu      sync.Mutex
+	kids    []string
+	jwksHit int
+	// code challenges of the authorization requests, by the code issued for them
+	challenges map[string]string
+	idToken    string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{
+		rsaKey:     rsaKey,
+		ecKey:      ecKey,
+		kids:       []string{"rsa-1", "ec-1"},
+		challenges: map[string]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.issuer,
+			"authorization_endpoint": m.issuer + "/authorize",
+			"token_endpoint":         m.issuer + "/token",
+			"jwks_uri":               m.issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksHit++
+		b64 := base64.RawURLEncoding
+		keys := []map[string]string{}
+		for _, kid := range m.kids {
+			if kid == "ec-1" {
+				keys = append(keys, map[string]string{
+					"kid": kid, "kty": "EC", "use": "sig", "crv": "P-256",
+					"x": b64.EncodeToString(m.ecKey.X.FillBytes(make([]byte, 32))),
+					"y": b64.EncodeToString(m.ecKey.Y.FillBytes(make([]byte, 32))),
+				})
+				continue
+			}
+			keys = append(keys, map[string]string{
+				"kid": kid, "kty": "RSA", "use": "sig",
+				"n": b64.EncodeToString(m.rsaKey.N.Bytes()),
+				"e": b64.EncodeToString(big.NewInt(int64(m.rsaKey.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, _, ok := r.BasicAuth()
+		if !ok || clientID != testClientID || r.FormValue("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		m.mu.Lock()
+		challenge, ok := m.challenges[r.FormValue("code")]
+		delete(m.challenges, r.FormValue("code"))
+		m.mu.Unlock()
+		if !ok || CodeChallenge(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken})
+	})
+	m.server = httptest.NewServer(mux)
+	m.issuer = m.server.URL
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) provider() *Provider {
+	return &Provider{
+		Issuer:       m.issuer,
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/oidc/callback",
+		Client:       m.server.Client(),
+	}
+}
+
+// authorize plays the user logging in at the authorization endpoint and returns the issued code
+func (m *mockProvider) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization url %s does not use S256 PKCE", authURL)
+	}
+	code := "code-" + u.Query().Get("state")
+	m.mu.Lock()
+	m.challenges[code] = u.Query().Get("code_challenge")
+	m.mu.Unlock()
+	return code
+}
+
+func validClaims(issuer string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+func (m *mockProvider) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	var key interface{} = m.rsaKey
+	if method == jwt.SigningMethodES256 {
+		key = m.ecKey
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestDiscoveryRejectsOtherIssuer(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	p.Issuer = m.issuer + "/other"
+
+	_, err := p.AuthCodeURL(context.Background(), "state", testNonce, CodeChallenge("verifier"))
+	if !errors.Is(err, ErrDiscovery) {
+		t.Fatalf("AuthCodeURL error = %v, want %v", err, ErrDiscovery)
+	}
+}
+
+func TestCodeFlowWithPKCE(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+	m.idToken = m.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims(m.issuer))
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, "state-1", testNonce, CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	code := m.authorize(t, authURL)
+	if _, err := p.Exchange(ctx, code, "not-the-verifier"); !errors.Is(err, ErrExchange) {
+		t.Fatalf("Exchange with the wrong verifier error = %v, want %v", err, ErrExchange)
+	}
+
+	code = m.authorize(t, authURL)
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := p.VerifyIDToken(ctx, raw, testNonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "user@example.com" || !claims.EmailVerified {
+		t.Fatalf("claims = %+v", claims)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		nonce string
+		want  error
+	}{
+		{
+			name:  "rs256",
+			token: func() string { return m.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims(m.issuer)) },
+		},
+		{
+			name:  "es256",
+			token: func() string { return m.sign(t, jwt.SigningMethodES256, "ec-1", validClaims(m.issuer)) },
+		},
+		{
+			name:  "wrong nonce",
+			token: func() string { return m.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims(m.issuer)) },
+			nonce: "another-nonce",
+			want:  ErrInvalidIDToken,
+		},
+		{
+			name: "other issuer",
+			token: func() string {
+				return m.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims("https://evil.example"))
+			},
+			want: ErrInvalidIDToken,
+		},
+		{
+			name: "other audience",
+			token: func() string {
+				claims := validClaims(m.issuer)
+				claims["aud"] = "another-client"
+				return m.sign(t, jwt.SigningMethodRS256, "rsa-1", claims)
+			},
+			want: ErrInvalidIDToken,
+		},
+		{
+			name: "several audiences without azp",
+			token: func() string {
+				claims := validClaims(m.issuer)
+				claims["aud"] = []string{testClientID, "another-client"}
+				return m.sign(t, jwt.SigningMethodRS256, "rsa-1", claims)
+			},
+			want: ErrInvalidIDToken,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := validClaims(m.issuer)
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+				return m.sign(t, jwt.SigningMethodRS256, "rsa-1", claims)
+			},
+			want: ErrInvalidIDToken,
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				claims := validClaims(m.issuer)
+				delete(claims, "exp")
+				return m.sign(t, jwt.SigningMethodRS256, "rsa-1", claims)
+			},
+			want: ErrInvalidIDToken,
+		},
+		{
+			name: "signed by another key",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims(m.issuer))
+				token.Header["kid"] = "rsa-1"
+				signed, _ := token.SignedString(otherKey)
+				return signed
+			},
+			want: ErrInvalidIDToken,
+		},
+		{
+			name:  "unknown key",
+			token: func() string { return m.sign(t, jwt.SigningMethodRS256, "rsa-9", validClaims(m.issuer)) },
+			want:  ErrUnknownKey,
+		},
+		{
+			name: "symmetric algorithm",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims(m.issuer))
+				token.Header["kid"] = "rsa-1"
+				signed, _ := token.SignedString([]byte("secret"))
+				return signed
+			},
+			want: ErrInvalidIDToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonce := tt.nonce
+			if nonce == "" {
+				nonce = testNonce
+			}
+			_, err := p.VerifyIDToken(context.Background(), tt.token(), nonce)
+			if tt.want == nil && err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyIDToken error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRefetchesRotatedKeys(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	_, err := p.VerifyIDToken(ctx, m.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims(m.issuer)), testNonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	m.mu.Lock()
+	m.kids = []string{"rsa-2"}
+	m.mu.Unlock()
+	_, err = p.VerifyIDToken(ctx, m.sign(t, jwt.SigningMethodRS256, "rsa-2", validClaims(m.issuer)), testNonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken after rotation: %v", err)
+	}
+	if m.jwksHit != 2 {
+		t.Fatalf("jwks fetched %d times, want 2", m.jwksHit)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// base64url of the sha256 digest of "abc", without padding
+	want := "ungWv48Bz-pBQUDeXa4iI7ADYaOWF3qctBD_YfIAFa0"
+	if got := CodeChallenge("abc"); got != want {
+		t.Fatalf("CodeChallenge(abc) = %q, want %q", got, want)
+	}
+}
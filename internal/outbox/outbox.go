@@ -0,0 +1,207 @@
+// Package outbox delivers domain events that are recorded in the same write as the change that
+// caused them, so an event is never lost when the process dies right after a commit. Events are
+// delivered to subscribers at least once, subscribers must tolerate seeing an event again.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/util"
+	"github.com/google/uuid"
+)
+
+var ErrNotExist = errors.New("outbox event does not exist")
+
+type Event struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Subject string          `json:"subject"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempts counts the claims of the event, including the one being dispatched
+	Attempts     int        `json:"attempts"`
+	AvailableAt  time.Time  `json:"available_at"`
+	LastError    string     `json:"last_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DispatchedAt *time.Time `json:"dispatched_at,omitempty"`
+}
+
+// NewEvent builds an event to record in the outbox. subject is the id of the user the event is about.
+func NewEvent(eventType string, subject string, data interface{}) (Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+	now := time.Now().UTC()
+	return Event{
+		ID:          uuid.NewString(),
+		Type:        eventType,
+		Subject:     subject,
+		Payload:     payload,
+		AvailableAt: now,
+		CreatedAt:   now,
+	}, nil
+}
+
+// Store holds the outbox of one database. Events are written by the database's own mutations,
+// the dispatcher only claims and settles them.
+type Store interface {
+	// ClaimOutboxEvents returns up to limit undispatched events available at now, with their
+	// attempts incremented and hidden from other claims until lockedUntil
+	ClaimOutboxEvents(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]Event, error)
+	MarkOutboxDispatched(ctx context.Context, id string) error
+	// MarkOutboxFailed makes the event available again at retryAt
+	MarkOutboxFailed(ctx context.Context, id string, retryAt time.Time, lastError string) error
+	PurgeOutbox(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// Subscriber handles an event. An error redelivers the event to every subscriber of its type later.
+type Subscriber func(ctx context.Context, event Event) error
+
+type Options struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// Timeout of delivering one event to all of its subscribers, its claim lasts a little longer
+	Timeout   time.Duration
+	RetryBase time.Duration
+	RetryMax  time.Duration
+}
+
+type subscription struct {
+	name string
+	fn   Subscriber
+}
+
+type Dispatcher struct {
+	stores      []Store
+	opts        Options
+	wake        chan struct{}
+	mu          sync.RWMutex
+	subscribers map[string][]subscription
+}
+
+func NewDispatcher(opts Options, stores ...Store) *Dispatcher {
+	return &Dispatcher{
+		stores:      stores,
+		opts:        opts,
+		wake:        make(chan struct{}, 1),
+		subscribers: map[string][]subscription{},
+	}
+}
+
+// Subscribe registers fn for events of eventType, name identifies it in logs
+func (d *Dispatcher) Subscribe(eventType string, name string, fn Subscriber) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[eventType] = append(d.subscribers[eventType], subscription{name: name, fn: fn})
+}
+
+// Notify wakes the dispatcher after a commit so new events don't wait for the next poll
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		d.Dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Dispatch delivers the available events of every store, oldest first. An event is marked
+// dispatched only once all of its subscribers succeeded.
+func (d *Dispatcher) Dispatch(ctx context.Context) {
+	for _, store := range d.stores {
+		for ctx.Err() == nil {
+			now := time.Now()
+			events, err := store.ClaimOutboxEvents(ctx, now, now.Add(d.opts.Timeout+time.Minute), d.opts.BatchSize)
+			if err != nil {
+				log.Printf("Failed to claim outbox events: %s", err)
+				break
+			}
+			sort.Slice(events, func(i, j int) bool {
+				return events[i].CreatedAt.Before(events[j].CreatedAt)
+			})
+			for _, event := range events {
+				d.deliver(ctx, store, event)
+			}
+			if len(events) < d.opts.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, store Store, event Event) {
+	d.mu.RLock()
+	subscribers := d.subscribers[event.Type]
+	d.mu.RUnlock()
+
+	// A delivery that started is finished on shutdown, its claim would only delay it otherwise
+	deliverCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d.opts.Timeout)
+	defer cancel()
+
+	var failed error
+	for _, sub := range subscribers {
+		err := call(deliverCtx, sub.fn, event)
+		if err != nil {
+			failed = fmt.Errorf("%s: %w", sub.name, err)
+			break
+		}
+	}
+
+	if failed == nil {
+		err := store.MarkOutboxDispatched(deliverCtx, event.ID)
+		if err != nil {
+			log.Printf("Failed to mark outbox event %s dispatched: %s", event.ID, err)
+		}
+		return
+	}
+
+	retryAt := time.Now().Add(util.Backoff(event.Attempts, d.opts.RetryBase, d.opts.RetryMax))
+	log.Printf("Outbox event %s (%s) failed on attempt %d, retrying at %s: %s", event.ID, event.Type, event.Attempts, retryAt.Format(time.RFC3339), failed)
+	err := store.MarkOutboxFailed(deliverCtx, event.ID, retryAt, failed.Error())
+	if err != nil {
+		log.Printf("Failed to reschedule outbox event %s: %s", event.ID, err)
+	}
+}
+
+// Purge removes events dispatched before cutoff from every store
+func (d *Dispatcher) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	total := 0
+	for _, store := range d.stores {
+		removed, err := store.PurgeOutbox(ctx, cutoff)
+		if err != nil {
+			return total, err
+		}
+		total += removed
+	}
+	return total, nil
+}
+
+// call runs a subscriber, turning a panic into an error so one bad event can't stop the dispatcher
+func call(ctx context.Context, fn Subscriber, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+	}()
+	return fn(ctx, event)
+}
@@ -0,0 +1,94 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	database2 "github.com/ethpalser/chirpy/internal/database/v2"
+	"github.com/google/uuid"
+)
+
+// PostgresStore dispatches the outbox_events table. Claims use SKIP LOCKED, so several servers
+// can dispatch it at once.
+type PostgresStore struct {
+	queries *database2.Queries
+}
+
+func NewPostgresStore(queries *database2.Queries) *PostgresStore {
+	return &PostgresStore{queries: queries}
+}
+
+// Insert records the event with queries, which must be bound to the transaction of the change
+// that caused it
+func Insert(ctx context.Context, queries *database2.Queries, event Event) error {
+	eventID, err := uuid.Parse(event.ID)
+	if err != nil {
+		return err
+	}
+	return queries.CreateOutboxEvent(ctx, database2.CreateOutboxEventParams{
+		ID:        eventID,
+		CreatedAt: event.CreatedAt,
+		Type:      event.Type,
+		Subject:   event.Subject,
+		Payload:   event.Payload,
+	})
+}
+
+func (s *PostgresStore) ClaimOutboxEvents(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]Event, error) {
+	dbEvents, err := s.queries.ClaimOutboxEvents(ctx, database2.ClaimOutboxEventsParams{
+		LockedUntil: lockedUntil,
+		Now:         now,
+		MaxRows:     int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(dbEvents))
+	for _, dbEvent := range dbEvents {
+		events = append(events, fromPostgres(dbEvent))
+	}
+	return events, nil
+}
+
+func (s *PostgresStore) MarkOutboxDispatched(ctx context.Context, id string) error {
+	eventID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrNotExist
+	}
+	return s.queries.MarkOutboxEventDispatched(ctx, eventID)
+}
+
+func (s *PostgresStore) MarkOutboxFailed(ctx context.Context, id string, retryAt time.Time, lastError string) error {
+	eventID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrNotExist
+	}
+	return s.queries.MarkOutboxEventFailed(ctx, database2.MarkOutboxEventFailedParams{
+		ID:          eventID,
+		AvailableAt: retryAt,
+		LastError:   lastError,
+	})
+}
+
+func (s *PostgresStore) PurgeOutbox(ctx context.Context, cutoff time.Time) (int, error) {
+	removed, err := s.queries.DeleteDispatchedOutboxEvents(ctx, sql.NullTime{Time: cutoff, Valid: true})
+	return int(removed), err
+}
+
+func fromPostgres(dbEvent database2.OutboxEvent) Event {
+	event := Event{
+		ID:          dbEvent.ID.String(),
+		Type:        dbEvent.Type,
+		Subject:     dbEvent.Subject,
+		Payload:     dbEvent.Payload,
+		Attempts:    int(dbEvent.Attempts),
+		AvailableAt: dbEvent.AvailableAt,
+		LastError:   dbEvent.LastError,
+		CreatedAt:   dbEvent.CreatedAt,
+	}
+	if dbEvent.DispatchedAt.Valid {
+		event.DispatchedAt = &dbEvent.DispatchedAt.Time
+	}
+	return event
+}
@@ -0,0 +1,384 @@
+// Package profanity finds listed words in text. Lists are plain text files of one word per line,
+// one file per language, and are matched on Unicode word boundaries regardless of case, accents,
+// basic leetspeak and letters repeated more often than in the listed word.
+package profanity
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Modes of handling a chirp with listed words in it
+const (
+	ModeReplace = "replace"
+	ModeReject  = "reject"
+	ModeFlag    = "flag"
+)
+
+// Mask replaces each listed word in replace mode
+const Mask = "****"
+
+var ErrInvalidLanguage = errors.New("language must be a code such as en or pt-br")
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// ValidMode reports whether mode is one of the modes
+func ValidMode(mode string) bool {
+	return mode == ModeReplace || mode == ModeReject || mode == ModeFlag
+}
+
+// Match is a listed word found in text, Start and End are its byte offsets
+type Match struct {
+	Text     string `json:"text"`
+	Word     string `json:"word"`
+	Language string `json:"language"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+type entry struct {
+	word     string
+	language string
+	// how many times each letter of the word's key repeats, text must repeat them at least as often
+	repeats []int
+}
+
+// Filter matches text against the lists in a directory. It is safe for concurrent use.
+type Filter struct {
+	dir   string
+	mu    sync.RWMutex
+	lists map[string][]string
+	// entries by key, longest first, so the most specific word that matches is reported
+	index    map[string][]entry
+	modTimes map[string]time.Time
+}
+
+// Load reads every <language>.txt list in dir, creating dir if it doesn't exist yet
+func Load(dir string) (*Filter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	filter := &Filter{dir: dir}
+	return filter, filter.Reload()
+}
+
+// Reload reads the lists again, replacing those in use only if all of them could be read
+func (f *Filter) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.load()
+}
+
+// load reads the lists into the filter, the caller holds the write lock
+func (f *Filter) load() error {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+
+	lists := map[string][]string{}
+	modTimes := map[string]time.Time{}
+	for _, path := range files {
+		language := strings.TrimSuffix(filepath.Base(path), ".txt")
+		if !languagePattern.MatchString(language) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		words, err := readList(path)
+		if err != nil {
+			return fmt.Errorf("invalid word list %s: %w", path, err)
+		}
+		lists[language] = words
+		modTimes[path] = info.ModTime()
+	}
+
+	index := map[string][]entry{}
+	for language, words := range lists {
+		for _, word := range words {
+			key, repeats := normalize(word)
+			index[key] = append(index[key], entry{word: word, language: language, repeats: repeats})
+		}
+	}
+	for _, entries := range index {
+		sort.Slice(entries, func(i, j int) bool {
+			if li, lj := sum(entries[i].repeats), sum(entries[j].repeats); li != lj {
+				return li > lj
+			}
+			return entries[i].language+entries[i].word < entries[j].language+entries[j].word
+		})
+	}
+	f.lists = lists
+	f.index = index
+	f.modTimes = modTimes
+	return nil
+}
+
+// ReloadIfChanged reloads the lists if a file was added, removed or modified since the last load
+func (f *Filter) ReloadIfChanged() (bool, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*.txt"))
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.RLock()
+	changed := len(files) != len(f.modTimes)
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(f.modTimes[path]) {
+			changed = true
+			break
+		}
+	}
+	f.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+	return true, f.Reload()
+}
+
+// Languages returns the words of every list by language
+func (f *Filter) Languages() map[string][]string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	languages := make(map[string][]string, len(f.lists))
+	for language, words := range f.lists {
+		languages[language] = append([]string{}, words...)
+	}
+	return languages
+}
+
+// Update replaces the list of a language with what change returns for its current words, and
+// returns the words before and after. A list left empty is removed.
+func (f *Filter) Update(language string, change func(words []string) []string) ([]string, []string, error) {
+	if !languagePattern.MatchString(language) {
+		return nil, nil, ErrInvalidLanguage
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	before := append([]string{}, f.lists[language]...)
+	after := cleanList(change(append([]string{}, before...)))
+	err := writeList(filepath.Join(f.dir, language+".txt"), after)
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, f.load()
+}
+
+// Find returns the listed words in text, in order
+func (f *Filter) Find(text string) []Match {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	matches := []Match{}
+	for _, span := range spans(text) {
+		if found, ok := f.lookup(text[span[0]:span[1]]); ok {
+			matches = append(matches, Match{
+				Text:     text[span[0]:span[1]],
+				Word:     found.word,
+				Language: found.language,
+				Start:    span[0],
+				End:      span[1],
+			})
+		}
+	}
+	return matches
+}
+
+// lookup returns the listed word that text is, the caller holds the read lock
+func (f *Filter) lookup(text string) (entry, bool) {
+	key, repeats := normalize(text)
+	for _, candidate := range f.index[key] {
+		if covers(repeats, candidate.repeats) {
+			return candidate, true
+		}
+	}
+	return entry{}, false
+}
+
+// covers reports whether every letter repeats at least as often in text as in the word. Both
+// have the same key, so they have as many letters.
+func covers(text []int, word []int) bool {
+	for i := range word {
+		if text[i] < word[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sum(counts []int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+// Censor replaces the listed words in text with Mask, leaving everything around them as it was
+func (f *Filter) Censor(text string) (string, []Match) {
+	matches := f.Find(text)
+	if len(matches) == 0 {
+		return text, matches
+	}
+
+	var censored strings.Builder
+	last := 0
+	for _, match := range matches {
+		censored.WriteString(text[last:match.Start])
+		censored.WriteString(Mask)
+		last = match.End
+	}
+	censored.WriteString(text[last:])
+	return censored.String(), matches
+}
+
+// spans returns the byte ranges of the words of text. Symbols used as letters in leetspeak join
+// the words next to them, so "$h4rbert" is one word rather than "$" and "h4rbert".
+func spans(text string) [][2]int {
+	result := [][2]int{}
+	start, end := -1, -1
+	pos := 0
+	state := -1
+	rest := text
+	var segment string
+	for len(rest) > 0 {
+		segment, rest, state = uniseg.FirstWordInString(rest, state)
+		if isWord(segment) || (len(segment) == 1 && leet[rune(segment[0])] != 0) {
+			if start < 0 || end != pos {
+				result = appendSpan(result, text, start, end)
+				start = pos
+			}
+			end = pos + len(segment)
+		}
+		pos += len(segment)
+	}
+	return appendSpan(result, text, start, end)
+}
+
+// appendSpan adds the span from start to end unless it has no letters or digits, like a lone "@"
+func appendSpan(result [][2]int, text string, start int, end int) [][2]int {
+	if start < 0 || !isWord(text[start:end]) {
+		return result
+	}
+	return append(result, [2]int{start, end})
+}
+
+func isWord(text string) bool {
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// leet maps the digits and symbols that stand in for letters
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+}
+
+// normalize reduces a word to the key lists are matched by: lower case, without accents or
+// leetspeak, and with repeated letters collapsed. It also returns how many times each letter of
+// the key repeated, so "KERRFUFFL3" has the key of "kerfuffle" and repeats its r more often,
+// while "as" has the key of "ass" but repeats its s less often.
+func normalize(word string) (string, []int) {
+	var key strings.Builder
+	repeats := []int{}
+	var last rune
+	for _, r := range norm.NFD.String(strings.ToLower(word)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if letter, ok := leet[r]; ok {
+			r = letter
+		}
+		if r == last {
+			repeats[len(repeats)-1]++
+			continue
+		}
+		key.WriteRune(r)
+		repeats = append(repeats, 1)
+		last = r
+	}
+	return key.String(), repeats
+}
+
+// readList reads one word per line, skipping blank lines and # comments
+func readList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return cleanList(words), scanner.Err()
+}
+
+// writeList replaces the file through a rename, so a reload never reads half a list
+func writeList(path string, words []string) error {
+	if len(words) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, []byte(strings.Join(words, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// cleanList lower cases, sorts and deduplicates words, dropping any that aren't a single word
+func cleanList(words []string) []string {
+	seen := map[string]bool{}
+	cleaned := []string{}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(norm.NFC.String(word)))
+		if word == "" || seen[word] || strings.ContainsFunc(word, unicode.IsSpace) {
+			continue
+		}
+		seen[word] = true
+		cleaned = append(cleaned, word)
+	}
+	sort.Strings(cleaned)
+	return cleaned
+}
@@ -0,0 +1,136 @@
+package profanity
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func newTestFilter(t *testing.T, lists map[string]string) *Filter {
+	t.Helper()
+	dir := t.TempDir()
+	for language, words := range lists {
+		err := os.WriteFile(filepath.Join(dir, language+".txt"), []byte(words), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	filter, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return filter
+}
+
+func TestFind(t *testing.T) {
+	filter := newTestFilter(t, map[string]string{
+		"en": "# comment\nkerfuffle\nsharbert\nass\nfornax\n",
+		"pt": "caralho\n",
+	})
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "listed word", text: "what a kerfuffle", want: []string{"kerfuffle"}},
+		{name: "case", text: "What a KERFUFFLE!", want: []string{"kerfuffle"}},
+		{name: "accents", text: "kérfüffle", want: []string{"kerfuffle"}},
+		{name: "leetspeak", text: "k3rfuffl3", want: []string{"kerfuffle"}},
+		{name: "leet symbol at the start", text: "$harbert", want: []string{"sharbert"}},
+		{name: "extra repeated letters", text: "kerrrfuuuffle", want: []string{"kerfuffle"}},
+		{name: "repeated letters in leetspeak", text: "a$$$", want: []string{"ass"}},
+		{name: "fewer repeats than listed", text: "as it is", want: nil},
+		{name: "fewer repeats in a longer word", text: "kerfufle", want: nil},
+		{name: "inside another word", text: "sharberts and fornaxes", want: nil},
+		{name: "another language", text: "caralho", want: []string{"caralho"}},
+		{name: "several", text: "fornax, kerfuffle and fornax", want: []string{"fornax", "kerfuffle", "fornax"}},
+		{name: "clean", text: "a nice day", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, match := range filter.Find(tt.text) {
+				got = append(got, match.Word)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Find(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPrefersTheMostSpecificWord(t *testing.T) {
+	filter := newTestFilter(t, map[string]string{"en": "as\nass\n"})
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "as", want: "as"},
+		{text: "ass", want: "ass"},
+		{text: "asssss", want: "ass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			matches := filter.Find(tt.text)
+			if len(matches) != 1 || matches[0].Word != tt.want {
+				t.Fatalf("Find(%q) = %+v, want %s", tt.text, matches, tt.want)
+			}
+		})
+	}
+}
+
+func TestCensor(t *testing.T) {
+	filter := newTestFilter(t, map[string]string{"en": "kerfuffle\nsharbert\n"})
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "I had a kerfuffle with a sharbert!", want: "I had a **** with a ****!"},
+		{text: "$h4rbert.", want: "****."},
+		{text: "nothing to see", want: "nothing to see"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, _ := filter.Censor(tt.text)
+			if got != tt.want {
+				t.Fatalf("Censor(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	filter := newTestFilter(t, map[string]string{"en": "kerfuffle\n"})
+
+	before, after, err := filter.Update("en", func(words []string) []string {
+		return append(words, " Fornax ", "kerfuffle", "two words")
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !slices.Equal(before, []string{"kerfuffle"}) || !slices.Equal(after, []string{"fornax", "kerfuffle"}) {
+		t.Fatalf("Update = %v, %v", before, after)
+	}
+	if len(filter.Find("fornax")) != 1 {
+		t.Fatal("added word is not matched")
+	}
+
+	_, _, err = filter.Update("not a language", func(words []string) []string { return words })
+	if !errors.Is(err, ErrInvalidLanguage) {
+		t.Fatalf("Update error = %v, want %v", err, ErrInvalidLanguage)
+	}
+
+	_, _, err = filter.Update("en", func(words []string) []string { return nil })
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, ok := filter.Languages()["en"]; ok {
+		t.Fatal("emptied list was not removed")
+	}
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff tracks failed attempts per key. Once a key reaches threshold failures it is locked out,
+// for base the first time and twice as long with each further failure, up to max.
+// Failures are forgotten after max has passed without any.
+type Backoff struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	entries   map[string]*failures
+	lastSweep time.Time
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func NewBackoff(threshold int, base time.Duration, max time.Duration) *Backoff {
+	return &Backoff{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		entries:   map[string]*failures{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Locked returns how long the key is still locked out for, or 0 if it isn't
+func (b *Backoff) Locked(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.entries[key]
+	if !ok {
+		return 0
+	}
+	remaining := time.Until(f.lockedUntil)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Fail records a failed attempt and returns how long the key is now locked out for, or 0 if it isn't
+func (b *Backoff) Fail(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.sweep(now)
+
+	f, ok := b.entries[key]
+	if !ok || now.Sub(f.last) > b.max {
+		f = &failures{}
+		b.entries[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < b.threshold {
+		return 0
+	}
+
+	lock := b.base
+	for i := b.threshold; i < f.count && lock < b.max; i++ {
+		lock *= 2
+	}
+	if lock > b.max {
+		lock = b.max
+	}
+	f.lockedUntil = now.Add(lock)
+	return lock
+}
+
+// Reset forgets the key's failures, after a successful attempt
+func (b *Backoff) Reset(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, key)
+}
+
+func (b *Backoff) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < b.max {
+		return
+	}
+	for key, f := range b.entries {
+		if now.Sub(f.last) > b.max && now.After(f.lockedUntil) {
+			delete(b.entries, key)
+		}
+	}
+	b.lastSweep = now
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows up to limit events per key in each fixed window of time.
+// It is in memory, so limits reset when the server restarts and are not shared between instances.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[string]*counter
+	lastSweep time.Time
+}
+
+type counter struct {
+	count int
+	start time.Time
+}
+
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		window:    window,
+		hits:      map[string]*counter{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	c, ok := l.hits[key]
+	if !ok || now.Sub(c.start) >= l.window {
+		l.hits[key] = &counter{count: 1, start: now}
+		return true
+	}
+	if c.count >= l.limit {
+		return false
+	}
+	c.count++
+	return true
+}
+
+// sweep drops finished windows, at most once per window, so keys that stop appearing don't leak memory
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for key, c := range l.hits {
+		if now.Sub(c.start) >= l.window {
+			delete(l.hits, key)
+		}
+	}
+	l.lastSweep = now
+}
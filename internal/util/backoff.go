@@ -0,0 +1,13 @@
+package util
+
+import "time"
+
+// Backoff is how long to wait before retrying after the given number of failed attempts,
+// doubling from base with each attempt up to max
+func Backoff(attempts int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
@@ -0,0 +1,104 @@
+package webauthn
+
+import (
+	"crypto/sha256"
+)
+
+// RequestOptions is the publicKey argument to navigator.credentials.get, with binary fields base64url encoded
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AssertionResponse is the PublicKeyCredential returned by navigator.credentials.get, base64url encoded
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// CredentialID decodes the id of the credential used, so the caller can look it up
+func (resp AssertionResponse) CredentialID() ([]byte, error) {
+	id, err := b64.DecodeString(resp.ID)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	return id, nil
+}
+
+// UserHandle decodes the user handle, which is empty unless the credential is discoverable
+func (resp AssertionResponse) UserHandle() ([]byte, error) {
+	handle, err := b64.DecodeString(resp.Response.UserHandle)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	return handle, nil
+}
+
+// RequestOptions allows any of the given credentials, or any discoverable credential if there are none
+func (rp RelyingParty) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	allowed := make([]credentialDescriptor, len(allow))
+	for i, id := range allow {
+		allowed[i] = credentialDescriptor{Type: "public-key", ID: b64.EncodeToString(id)}
+	}
+	return RequestOptions{
+		Challenge:        b64.EncodeToString(challenge),
+		RPID:             rp.ID,
+		Timeout:          60000,
+		AllowCredentials: allowed,
+		UserVerification: "preferred",
+	}
+}
+
+// VerifyAssertion checks an authentication ceremony signed by cred and returns the authenticator's new sign count
+func (rp RelyingParty) VerifyAssertion(challenge []byte, cred Credential, resp AssertionResponse) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, ErrInvalidResponse
+	}
+	rawClientData, err := b64.DecodeString(resp.Response.ClientDataJSON)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	clientDataHash, err := rp.verifyClientData(rawClientData, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := b64.DecodeString(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	signature, err := b64.DecodeString(resp.Response.Signature)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash...)
+	digest := sha256.Sum256(signed)
+	err = key.verify(digest[:], signature)
+	if err != nil {
+		return 0, err
+	}
+
+	// Authenticators that don't keep a counter always report 0
+	if (authData.SignCount != 0 || cred.SignCount != 0) && authData.SignCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return authData.SignCount, nil
+}
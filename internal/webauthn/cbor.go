@@ -0,0 +1,130 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var errCBOR = errors.New("malformed cbor")
+
+// maxCBORDepth bounds nesting so a hostile payload cannot exhaust the stack
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first CBOR item in b and returns it with the remaining bytes.
+// It supports the subset WebAuthn uses: integers (as int64), byte and text strings, arrays, maps,
+// booleans, null and floats. Indefinite lengths and tags are rejected.
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth || len(b) == 0 {
+		return nil, nil, errCBOR
+	}
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	if major == 7 {
+		return decodeCBORSimple(b, info)
+	}
+
+	arg, rest, err := decodeCBORArgument(b[1:], info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		data := rest[:arg]
+		if major == 3 {
+			return string(data), rest[arg:], nil
+		}
+		return append([]byte{}, data...), rest[arg:], nil
+	case 4:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		arr := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, rest, nil
+	case 5:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, val interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			val, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = val
+		}
+		return m, rest, nil
+	}
+	// Tags (6) are not used by WebAuthn
+	return nil, nil, errCBOR
+}
+
+func decodeCBORArgument(b []byte, info byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24 && len(b) >= 1:
+		return uint64(b[0]), b[1:], nil
+	case info == 25 && len(b) >= 2:
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26 && len(b) >= 4:
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27 && len(b) >= 8:
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+	return 0, nil, errCBOR
+}
+
+func decodeCBORSimple(b []byte, info byte) (interface{}, []byte, error) {
+	rest := b[1:]
+	switch {
+	case info == 20:
+		return false, rest, nil
+	case info == 21:
+		return true, rest, nil
+	case info == 22 || info == 23:
+		return nil, rest, nil
+	case info == 26 && len(rest) >= 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(rest))), rest[4:], nil
+	case info == 27 && len(rest) >= 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(rest)), rest[8:], nil
+	}
+	return nil, nil, errCBOR
+}
@@ -0,0 +1,93 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported webauthn public key")
+var ErrBadSignature = errors.New("invalid webauthn signature")
+
+// COSE algorithm identifiers, see the IANA COSE registry
+const (
+	algES256 = -7
+	algRS256 = -257
+)
+
+// COSE_Key labels
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+)
+
+type publicKey struct {
+	ec  *ecdsa.PublicKey
+	rsa *rsa.PublicKey
+}
+
+// parsePublicKey reads an ES256 or RS256 COSE_Key
+func parsePublicKey(raw []byte) (publicKey, error) {
+	decoded, _, err := decodeCBOR(raw)
+	if err != nil {
+		return publicKey{}, ErrUnsupportedKey
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return publicKey{}, ErrUnsupportedKey
+	}
+	alg, _ := m[int64(coseAlg)].(int64)
+	kty, _ := m[int64(coseKty)].(int64)
+
+	switch {
+	case alg == algES256 && kty == 2:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, okX := m[int64(coseX)].([]byte)
+		y, okY := m[int64(coseY)].([]byte)
+		if crv != 1 || !okX || !okY {
+			return publicKey{}, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return publicKey{}, ErrUnsupportedKey
+		}
+		return publicKey{ec: key}, nil
+	case alg == algRS256 && kty == 3:
+		n, okN := m[int64(coseN)].([]byte)
+		e, okE := m[int64(coseE)].([]byte)
+		if !okN || !okE || len(e) > 4 {
+			return publicKey{}, ErrUnsupportedKey
+		}
+		return publicKey{rsa: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	}
+	return publicKey{}, ErrUnsupportedKey
+}
+
+// verify checks a signature over a SHA-256 digest
+func (k publicKey) verify(digest []byte, signature []byte) error {
+	if k.ec != nil {
+		if !ecdsa.VerifyASN1(k.ec, digest, signature) {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	if rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest, signature) != nil {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package webauthn
+
+// CreationOptions is the publicKey argument to navigator.credentials.create, with binary fields base64url encoded
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// AttestationResponse is the PublicKeyCredential returned by navigator.credentials.create, base64url encoded
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+func (rp RelyingParty) CreationOptions(challenge []byte, userHandle []byte, userName string, exclude [][]byte) CreationOptions {
+	excluded := make([]credentialDescriptor, len(exclude))
+	for i, id := range exclude {
+		excluded[i] = credentialDescriptor{Type: "public-key", ID: b64.EncodeToString(id)}
+	}
+	return CreationOptions{
+		Challenge: b64.EncodeToString(challenge),
+		RP:        rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID:          b64.EncodeToString(userHandle),
+			Name:        userName,
+			DisplayName: userName,
+		},
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: algES256},
+			{Type: "public-key", Alg: algRS256},
+		},
+		Timeout:            60000,
+		ExcludeCredentials: excluded,
+		AuthenticatorSelection: authenticatorSelection{
+			// Login doesn't list credentials, so only discoverable ones can be used
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "preferred",
+		},
+		Attestation: "none",
+	}
+}
+
+// VerifyRegistration checks a registration ceremony and returns the new credential.
+// Attestation statements are not verified, as CreationOptions asks for none.
+func (rp RelyingParty) VerifyRegistration(challenge []byte, resp AttestationResponse) (Credential, error) {
+	if resp.Type != "public-key" {
+		return Credential{}, ErrInvalidResponse
+	}
+	rawClientData, err := b64.DecodeString(resp.Response.ClientDataJSON)
+	if err != nil {
+		return Credential{}, ErrInvalidResponse
+	}
+	_, err = rp.verifyClientData(rawClientData, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	rawAttestation, err := b64.DecodeString(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, ErrInvalidResponse
+	}
+	decoded, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return Credential{}, ErrInvalidResponse
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, ErrInvalidResponse
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.CredentialID == nil {
+		return Credential{}, ErrInvalidResponse
+	}
+	_, err = parsePublicKey(authData.PublicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:        authData.CredentialID,
+		PublicKey: authData.PublicKey,
+		SignCount: authData.SignCount,
+	}, nil
+}
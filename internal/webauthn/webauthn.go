@@ -0,0 +1,128 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidResponse = errors.New("invalid webauthn response")
+var ErrChallengeMismatch = errors.New("webauthn challenge does not match")
+var ErrOriginMismatch = errors.New("webauthn origin does not match")
+var ErrUserNotPresent = errors.New("webauthn user presence not confirmed")
+var ErrSignCount = errors.New("webauthn sign count did not increase, the authenticator may be cloned")
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var b64 = base64.RawURLEncoding
+
+// RelyingParty is this server as the WebAuthn spec sees it.
+// ID is the domain credentials are scoped to, Origin is the exact origin the browser reports.
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	SignCount uint32
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// NewChallenge returns 32 random bytes for a ceremony
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// verifyClientData checks the browser's collected client data against the ceremony and returns its hash
+func (rp RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) ([]byte, error) {
+	cd := clientData{}
+	err := json.Unmarshal(raw, &cd)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	if cd.Type != ceremony {
+		return nil, ErrInvalidResponse
+	}
+	got, err := b64.DecodeString(cd.Challenge)
+	if err != nil || !bytes.Equal(got, challenge) {
+		return nil, ErrChallengeMismatch
+	}
+	if cd.Origin != rp.Origin || cd.CrossOrigin {
+		return nil, ErrOriginMismatch
+	}
+	sum := sha256.Sum256(raw)
+	return sum[:], nil
+}
+
+// parseAuthenticatorData parses the layout in WebAuthn section 6.1 and checks it was made for this relying party
+func (rp RelyingParty) parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	if len(raw) < 37 {
+		return authenticatorData{}, ErrInvalidResponse
+	}
+	ad := authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return authenticatorData{}, ErrInvalidResponse
+	}
+	if ad.Flags&flagUserPresent == 0 {
+		return authenticatorData{}, ErrUserNotPresent
+	}
+
+	if ad.Flags&flagAttestedData != 0 {
+		// aaguid (16) then the credential id length (2)
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return authenticatorData{}, ErrInvalidResponse
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return authenticatorData{}, ErrInvalidResponse
+		}
+		ad.CredentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, ErrInvalidResponse
+		}
+		ad.PublicKey = rest[:len(rest)-len(after)]
+	}
+	return ad, nil
+}
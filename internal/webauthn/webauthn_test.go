@@ -0,0 +1,367 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var testRP = RelyingParty{ID: "localhost", Name: "Chirpy", Origin: "http://localhost:8080"}
+
+// softAuthenticator is a software ES256 authenticator that makes the responses a browser would return
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	signCount  uint32
+	// fields a test changes to make an invalid response
+	rpID   string
+	origin string
+	flags  byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	if _, err := rand.Read(credID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		key:        key,
+		credID:     credID,
+		userHandle: []byte("42"),
+		rpID:       testRP.ID,
+		origin:     testRP.Origin,
+		flags:      flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	x := a.key.X.FillBytes(make([]byte, 32))
+	y := a.key.Y.FillBytes(make([]byte, 32))
+	return encodeCBOR(map[interface{}]interface{}{
+		int64(coseKty): int64(2),
+		int64(coseAlg): int64(algES256),
+		int64(coseCrv): int64(1),
+		int64(coseX):   x,
+		int64(coseY):   y,
+	})
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // aaguid
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credID)))
+		data = append(data, a.credID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	raw, _ := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: b64.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	return raw
+}
+
+func (a *softAuthenticator) create(challenge []byte) AttestationResponse {
+	attestation := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(true),
+	})
+	resp := AttestationResponse{ID: b64.EncodeToString(a.credID), Type: "public-key"}
+	resp.Response.ClientDataJSON = b64.EncodeToString(a.clientData("webauthn.create", challenge))
+	resp.Response.AttestationObject = b64.EncodeToString(attestation)
+	return resp
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge []byte) AssertionResponse {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(false)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := AssertionResponse{ID: b64.EncodeToString(a.credID), Type: "public-key"}
+	resp.Response.ClientDataJSON = b64.EncodeToString(clientData)
+	resp.Response.AuthenticatorData = b64.EncodeToString(authData)
+	resp.Response.Signature = b64.EncodeToString(signature)
+	resp.Response.UserHandle = b64.EncodeToString(a.userHandle)
+	return resp
+}
+
+// encodeCBOR encodes the subset of CBOR that decodeCBOR supports, for building test responses
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		out := head(5, uint64(len(v)))
+		for key, val := range v {
+			out = append(out, encodeCBOR(key)...)
+			out = append(out, encodeCBOR(val)...)
+		}
+		return out
+	}
+	panic("unsupported cbor type")
+}
+
+func mustChallenge(t *testing.T) []byte {
+	t.Helper()
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func register(t *testing.T, a *softAuthenticator) Credential {
+	t.Helper()
+	challenge := mustChallenge(t)
+	cred, err := testRP.VerifyRegistration(challenge, a.create(challenge))
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return cred
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	a := newSoftAuthenticator(t)
+	cred := register(t, a)
+	if string(cred.ID) != string(a.credID) {
+		t.Fatalf("credential id = %x, want %x", cred.ID, a.credID)
+	}
+
+	for i := 0; i < 2; i++ {
+		challenge := mustChallenge(t)
+		resp := a.get(t, challenge)
+		signCount, err := testRP.VerifyAssertion(challenge, cred, resp)
+		if err != nil {
+			t.Fatalf("VerifyAssertion: %v", err)
+		}
+		if signCount != a.signCount {
+			t.Fatalf("sign count = %d, want %d", signCount, a.signCount)
+		}
+		cred.SignCount = signCount
+
+		handle, err := resp.UserHandle()
+		if err != nil || string(handle) != "42" {
+			t.Fatalf("user handle = %q, %v", handle, err)
+		}
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(a *softAuthenticator, challenge []byte) ([]byte, AttestationResponse)
+		want   error
+	}{
+		{
+			name: "other challenge",
+			change: func(a *softAuthenticator, challenge []byte) ([]byte, AttestationResponse) {
+				return challenge, a.create([]byte("not the challenge"))
+			},
+			want: ErrChallengeMismatch,
+		},
+		{
+			name: "other origin",
+			change: func(a *softAuthenticator, challenge []byte) ([]byte, AttestationResponse) {
+				a.origin = "https://evil.example"
+				return challenge, a.create(challenge)
+			},
+			want: ErrOriginMismatch,
+		},
+		{
+			name: "other relying party",
+			change: func(a *softAuthenticator, challenge []byte) ([]byte, AttestationResponse) {
+				a.rpID = "evil.example"
+				return challenge, a.create(challenge)
+			},
+			want: ErrInvalidResponse,
+		},
+		{
+			name: "user not present",
+			change: func(a *softAuthenticator, challenge []byte) ([]byte, AttestationResponse) {
+				a.flags = 0
+				return challenge, a.create(challenge)
+			},
+			want: ErrUserNotPresent,
+		},
+		{
+			name: "login response",
+			change: func(a *softAuthenticator, challenge []byte) ([]byte, AttestationResponse) {
+				resp := a.create(challenge)
+				resp.Response.ClientDataJSON = b64.EncodeToString(a.clientData("webauthn.get", challenge))
+				return challenge, resp
+			},
+			want: ErrInvalidResponse,
+		},
+		{
+			name: "truncated attestation",
+			change: func(a *softAuthenticator, challenge []byte) ([]byte, AttestationResponse) {
+				resp := a.create(challenge)
+				raw, _ := b64.DecodeString(resp.Response.AttestationObject)
+				resp.Response.AttestationObject = b64.EncodeToString(raw[:len(raw)/2])
+				return challenge, resp
+			},
+			want: ErrInvalidResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, resp := tt.change(newSoftAuthenticator(t), mustChallenge(t))
+			_, err := testRP.VerifyRegistration(challenge, resp)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyRegistration error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, a *softAuthenticator, cred *Credential, challenge []byte) AssertionResponse
+		want   error
+	}{
+		{
+			name: "other challenge",
+			change: func(t *testing.T, a *softAuthenticator, cred *Credential, challenge []byte) AssertionResponse {
+				return a.get(t, []byte("not the challenge"))
+			},
+			want: ErrChallengeMismatch,
+		},
+		{
+			name: "other origin",
+			change: func(t *testing.T, a *softAuthenticator, cred *Credential, challenge []byte) AssertionResponse {
+				a.origin = "https://evil.example"
+				return a.get(t, challenge)
+			},
+			want: ErrOriginMismatch,
+		},
+		{
+			name: "signed by another key",
+			change: func(t *testing.T, a *softAuthenticator, cred *Credential, challenge []byte) AssertionResponse {
+				other := newSoftAuthenticator(t)
+				other.credID = a.credID
+				return other.get(t, challenge)
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered authenticator data",
+			change: func(t *testing.T, a *softAuthenticator, cred *Credential, challenge []byte) AssertionResponse {
+				resp := a.get(t, challenge)
+				raw, _ := b64.DecodeString(resp.Response.AuthenticatorData)
+				raw[36]++
+				resp.Response.AuthenticatorData = b64.EncodeToString(raw)
+				return resp
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "sign count did not increase",
+			change: func(t *testing.T, a *softAuthenticator, cred *Credential, challenge []byte) AssertionResponse {
+				cred.SignCount = 10
+				a.signCount = 9
+				return a.get(t, challenge)
+			},
+			want: ErrSignCount,
+		},
+		{
+			name: "registration response",
+			change: func(t *testing.T, a *softAuthenticator, cred *Credential, challenge []byte) AssertionResponse {
+				resp := a.get(t, challenge)
+				resp.Response.ClientDataJSON = b64.EncodeToString(a.clientData("webauthn.create", challenge))
+				return resp
+			},
+			want: ErrInvalidResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t)
+			cred := register(t, a)
+			challenge := mustChallenge(t)
+			resp := tt.change(t, a, &cred, challenge)
+			_, err := testRP.VerifyAssertion(challenge, cred, resp)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyAssertion error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestOptionsWithoutCredentials(t *testing.T) {
+	raw, err := json.Marshal(testRP.RequestOptions(mustChallenge(t), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		t.Fatal(err)
+	}
+	allow, ok := opts["allowCredentials"].([]interface{})
+	if !ok || len(allow) != 0 {
+		t.Fatalf("allowCredentials = %v, want an empty list", opts["allowCredentials"])
+	}
+}
+
+func TestDecodeCBORRejectsDeepNesting(t *testing.T) {
+	// an array nested deeper than maxCBORDepth
+	raw := []byte{}
+	for i := 0; i <= maxCBORDepth+1; i++ {
+		raw = append(raw, 0x81)
+	}
+	raw = append(raw, 0x00)
+	if _, _, err := decodeCBOR(raw); err == nil {
+		t.Fatal("decodeCBOR accepted nesting deeper than maxCBORDepth")
+	}
+}
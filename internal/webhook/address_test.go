@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "93.184.216.34", want: true},
+		{addr: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{addr: "127.0.0.1", want: false},
+		{addr: "::1", want: false},
+		{addr: "10.1.2.3", want: false},
+		{addr: "172.16.0.1", want: false},
+		{addr: "192.168.1.1", want: false},
+		{addr: "fd00::1", want: false},
+		{addr: "169.254.169.254", want: false},
+		{addr: "fe80::1", want: false},
+		{addr: "0.0.0.0", want: false},
+		{addr: "::", want: false},
+		{addr: "100.64.0.1", want: false},
+		{addr: "224.0.0.1", want: false},
+		{addr: "::ffff:127.0.0.1", want: false},
+		{addr: "::ffff:93.184.216.34", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := PublicAddress(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Fatalf("PublicAddress(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	if err := DialControl("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Fatalf("DialControl public address: %v", err)
+	}
+	if err := DialControl("tcp", "127.0.0.1:8080", nil); !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("DialControl loopback error = %v, want %v", err, ErrPrivateAddress)
+	}
+	if err := DialControl("tcp6", "[::1]:8080", nil); !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("DialControl ipv6 loopback error = %v, want %v", err, ErrPrivateAddress)
+	}
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":1}}`)
+	now := time.Unix(1700000000, 0)
+	tolerance := 5 * time.Minute
+	valid := Sign(secret, body, now)
+	other := Sign("whsec_old", body, now)
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		want   error
+	}{
+		{name: "valid", header: valid},
+		{name: "missing", header: "", want: ErrMissingSignature},
+		{name: "wrong secret", header: other, want: ErrInvalidSignature},
+		{
+			name:   "rotated secret",
+			header: fmt.Sprintf("%s,%s", other, valid[len("t=1700000000,"):]),
+		},
+		{name: "tampered body", header: valid, body: []byte(`{"event":"user.upgraded","data":{"user_id":2}}`), want: ErrInvalidSignature},
+		{name: "no timestamp", header: valid[len("t=1700000000,"):], want: ErrInvalidSignature},
+		{name: "no signature", header: "t=1700000000", want: ErrInvalidSignature},
+		{name: "timestamp not a number", header: "t=soon," + valid[len("t=1700000000,"):], want: ErrInvalidSignature},
+		{name: "signature not hex", header: "t=1700000000,v1=zz", want: ErrInvalidSignature},
+		{name: "too old", header: Sign(secret, body, now.Add(-tolerance-time.Second)), want: ErrExpiredSignature},
+		{name: "too far ahead", header: Sign(secret, body, now.Add(tolerance+time.Second)), want: ErrExpiredSignature},
+		{name: "at the tolerance", header: Sign(secret, body, now.Add(-tolerance))},
+		{name: "spaces between parts", header: "t=1700000000, " + valid[len("t=1700000000,"):]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.body
+			if b == nil {
+				b = body
+			}
+			err := Verify(secret, tt.header, b, tolerance, now)
+			if tt.want == nil && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
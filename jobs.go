@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/jobs"
+)
+
+// Types of background job
+const (
+	jobVerificationEmail  = "email.verification"
+	jobPasswordResetEmail = "email.password_reset"
+	jobMagicLinkEmail     = "email.magic_link"
+	jobUserExport         = "user.export"
+)
+
+// newJobQueue keeps jobs in postgres when JOBS_BACKEND=postgres, otherwise in the json db
+func (cfg *apiConfig) newJobQueue() *jobs.Queue {
+	var store jobs.Store = &cfg.database
+	if os.Getenv("JOBS_BACKEND") == "postgres" {
+		store = jobs.NewPostgresStore(cfg.dbQueries)
+	}
+
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers < 1 {
+		workers = min(runtime.NumCPU(), 4)
+	}
+	queue := jobs.New(store, jobs.Options{
+		Workers:      workers,
+		PollInterval: envDuration("JOB_POLL_INTERVAL", time.Second),
+		MaxAttempts:  5,
+		Timeout:      time.Minute,
+		RetryBase:    10 * time.Second,
+		RetryMax:     time.Hour,
+	})
+	queue.Register(jobVerificationEmail, cfg.sendVerificationEmail)
+	queue.Register(jobPasswordResetEmail, cfg.sendPasswordResetEmail)
+	queue.Register(jobMagicLinkEmail, cfg.sendMagicLinkEmail)
+	queue.Register(jobUserExport, cfg.buildExport)
+	return queue
+}
@@ -3,14 +3,33 @@ package main
 import _ "github.com/lib/pq"
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"database/sql"
+	"sync"
+	"syscall"
+	"time"
 
 	database "github.com/ethpalser/chirpy/internal/database"
 	database2 "github.com/ethpalser/chirpy/internal/database/v2"
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/billing"
+	"github.com/ethpalser/chirpy/internal/entitlements"
+	"github.com/ethpalser/chirpy/internal/jobs"
+	"github.com/ethpalser/chirpy/internal/outbox"
+	"github.com/ethpalser/chirpy/internal/mailer"
+	"github.com/ethpalser/chirpy/internal/oidc"
+	"github.com/ethpalser/chirpy/internal/profanity"
+	"github.com/ethpalser/chirpy/internal/ratelimit"
+	"github.com/ethpalser/chirpy/internal/webauthn"
 	"github.com/joho/godotenv"
 )
 
@@ -18,8 +37,37 @@ type apiConfig struct {
 	fileserverHits 	int
 	database	database.DB
 	dbQueries	*database2.Queries
+	pgDB	*sql.DB
 	jwtSecret      	string
 	polkaApiKey    	string
+	polkaWebhookSecret	string
+	polkaWebhookTolerance	time.Duration
+	billingPolicy	billing.Policy
+	entitlements	*entitlements.Service
+	webhookClient	*http.Client
+	mailer		mailer.Mailer
+	publicURL	string
+	unverifiedLimits accountLimits
+	relyingParty	webauthn.RelyingParty
+	magicLinkEmailLimiter *ratelimit.Limiter
+	magicLinkIPLimiter    *ratelimit.Limiter
+	oidcProvider	*oidc.Provider
+	loginAccountBackoff *ratelimit.Backoff
+	loginIPBackoff      *ratelimit.Backoff
+	passwordPolicy	*auth.PasswordPolicy
+	reauthWindow	time.Duration
+	deletionGrace	time.Duration
+	exportDir	string
+	jobs	*jobs.Queue
+	outbox	*outbox.Dispatcher
+	profanity	*profanity.Filter
+	profanityMode	string
+	platform	string
+}
+
+// accountLimits are the restrictions placed on accounts that have not verified their email
+type accountLimits struct {
+	CanPost bool
 }
 
 func main() {
@@ -31,6 +79,70 @@ func main() {
 	dbSource := os.Getenv("DB_SOURCE")
 	dbURL := os.Getenv("DB_URL")
 	polkaApiKey := os.Getenv("POLKA_API_KEY")
+	polkaWebhookSecret := os.Getenv("POLKA_WEBHOOK_SECRET")
+	if polkaWebhookSecret == "" {
+		log.Println("POLKA_WEBHOOK_SECRET is not set, Polka webhooks are only checked against POLKA_API_KEY")
+	}
+	sweepInterval := envDuration("TOKEN_SWEEP_INTERVAL", time.Hour)
+	retention := sweepRetention{
+		Tokens: envDuration("TOKEN_RETENTION", 24*time.Hour),
+		Jobs:   envDuration("JOB_RETENTION", 7*24*time.Hour),
+		Outbox: envDuration("OUTBOX_RETENTION", 24*time.Hour),
+	}
+	deletionGrace := envDuration("ACCOUNT_DELETION_GRACE", 7*24*time.Hour)
+	// Exports are only served through the authenticated download, never from the directory served at /app
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = filepath.Join(os.TempDir(), "chirpy", "exports")
+	}
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+	relyingParty := webauthn.RelyingParty{
+		ID:     os.Getenv("WEBAUTHN_RP_ID"),
+		Name:   "Chirpy",
+		Origin: os.Getenv("WEBAUTHN_ORIGIN"),
+	}
+	if relyingParty.ID == "" {
+		relyingParty.ID = "localhost"
+	}
+	if relyingParty.Origin == "" {
+		relyingParty.Origin = publicURL
+	}
+	// Single sign-on is only enabled when an identity provider is configured
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		oidcProvider = &oidc.Provider{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  publicURL + "/api/oidc/callback",
+			Client:       &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	passwordMinLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	if err != nil {
+		passwordMinLength = 8
+	}
+	passwordPolicy, err := auth.LoadPasswordPolicy(passwordMinLength, os.Getenv("PASSWORD_BREACHED_LIST"))
+	if err != nil {
+		log.Fatalf("Error loading breached password list: %s", err)
+	}
+	entitlementsFile := os.Getenv("ENTITLEMENTS_FILE")
+	if entitlementsFile == "" {
+		entitlementsFile = "entitlements.json"
+	}
+	planEntitlements, err := entitlements.Load(entitlementsFile)
+	if errors.Is(err, os.ErrNotExist) && os.Getenv("ENTITLEMENTS_FILE") == "" {
+		log.Printf("No %s found, using the default entitlements", entitlementsFile)
+		planEntitlements = entitlements.Default()
+	} else if err != nil {
+		log.Fatalf("Error loading entitlements: %s", err)
+	}
+	unverifiedLimits := accountLimits{
+		CanPost: os.Getenv("UNVERIFIED_CAN_POST") == "true",
+	}
 
 	db, err := database.NewDB(dbSource)
 	if err != nil {
@@ -45,21 +157,69 @@ func main() {
 	dbQueries := database2.New(db2)
 
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	bootstrapAdmin := flag.String("bootstrap-admin", "", "Promote the user with this email to admin if there is no admin yet, then exit")
 	flag.Parse()
 	if dbg != nil && *dbg {
 		dbErr := db.ResetDB()
 		if dbErr != nil {
 			log.Fatal(dbErr)
 		}
+		// The audit log is kept through the reset, and records it
+		_, dbErr = db.AppendAuditEntry(audit.Entry{
+			Actor:  audit.Actor{Type: audit.ActorSystem},
+			Action: auditAdminReset,
+			Target: audit.Target{Type: targetDatabase, ID: "json"},
+			Reason: "started with -debug",
+		})
+		if dbErr != nil {
+			log.Fatal(dbErr)
+		}
+	}
+	if *bootstrapAdmin != "" {
+		admin, adminErr := db.PromoteFirstAdmin(*bootstrapAdmin)
+		if errors.Is(adminErr, database.ErrConflict) {
+			log.Fatal("An admin already exists, promote other users through the admin API")
+		}
+		if adminErr != nil {
+			log.Fatalf("Failed to promote %s: %s", *bootstrapAdmin, adminErr)
+		}
+		log.Printf("Promoted user %d (%s) to admin", admin.Id, admin.Email)
+		return
 	}
 
 	apiCfg := apiConfig{
 		fileserverHits: 0,
 		database:       *db,
 		dbQueries:	dbQueries,
+		pgDB:	db2,
 		jwtSecret:      jwtSecret,
 		polkaApiKey:    polkaApiKey,
+		polkaWebhookSecret:	polkaWebhookSecret,
+		polkaWebhookTolerance:	envDuration("POLKA_WEBHOOK_TOLERANCE", 5*time.Minute),
+		entitlements:	planEntitlements,
+		webhookClient:	newWebhookClient(os.Getenv("PLATFORM") == "dev"),
+		billingPolicy:	billing.Policy{
+			PaymentGrace:	envDuration("SUBSCRIPTION_PAYMENT_GRACE", 3*24*time.Hour),
+			RenewalLeeway:	envDuration("SUBSCRIPTION_RENEWAL_LEEWAY", 24*time.Hour),
+		},
+		mailer:		newMailer(),
+		publicURL:	publicURL,
+		unverifiedLimits: unverifiedLimits,
+		relyingParty:	relyingParty,
+		magicLinkEmailLimiter: ratelimit.New(3, 15*time.Minute),
+		magicLinkIPLimiter:    ratelimit.New(10, 15*time.Minute),
+		oidcProvider:	oidcProvider,
+		loginAccountBackoff: ratelimit.NewBackoff(5, 30*time.Second, time.Hour),
+		loginIPBackoff:      ratelimit.NewBackoff(20, 30*time.Second, time.Hour),
+		passwordPolicy:	passwordPolicy,
+		reauthWindow:	envDuration("REAUTH_WINDOW", 10*time.Minute),
+		deletionGrace:	deletionGrace,
+		exportDir:	exportDir,
+		platform:	os.Getenv("PLATFORM"),
 	}
+	apiCfg.jobs = apiCfg.newJobQueue()
+	apiCfg.outbox = apiCfg.newOutbox()
+	apiCfg.profanity, apiCfg.profanityMode = newProfanityFilter()
 
 	// Create a multiplexer that can handle HTTP requests for a server at its endpoints
 	mux := http.NewServeMux()
@@ -67,14 +227,49 @@ func main() {
 	mux.Handle("/app/*", handler)
 	// General APIs
 	mux.HandleFunc("GET /api/healthz", health)
-	mux.HandleFunc("GET /api/reset", apiCfg.handlerMetricsReset)
+	adminOnly := []string{auth.RoleAdmin}
+	staffOnly := []string{auth.RoleAdmin, auth.RoleModerator}
+	mux.HandleFunc("GET /api/reset", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerMetricsReset))
 	// Admin APIs
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.HandleFunc("POST /admin/reset", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerReset))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminUsersRole))
+	mux.HandleFunc("POST /admin/users/{userID}/suspension", apiCfg.middlewareRequireRole(staffOnly, apiCfg.handlerAdminUsersSuspend))
+	mux.HandleFunc("DELETE /admin/users/{userID}/suspension", apiCfg.middlewareRequireRole(staffOnly, apiCfg.handlerAdminUsersUnsuspend))
+	mux.HandleFunc("GET /admin/webhooks/polka/events", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminPolkaEvents))
+	mux.HandleFunc("POST /admin/webhooks/polka/events/{eventID}/replay", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminPolkaReplay))
+	mux.HandleFunc("GET /admin/jobs", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminJobs))
+	mux.HandleFunc("GET /admin/jobs/{jobID}", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminJobsGet))
+	mux.HandleFunc("POST /admin/jobs/{jobID}/retry", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminJobsRetry))
+	mux.HandleFunc("GET /admin/audit", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminAudit))
+	mux.HandleFunc("GET /admin/audit/export", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminAuditExport))
+	mux.HandleFunc("GET /admin/profanity", apiCfg.middlewareRequireRole(staffOnly, apiCfg.handlerAdminProfanity))
+	mux.HandleFunc("POST /admin/profanity/check", apiCfg.middlewareRequireRole(staffOnly, apiCfg.handlerAdminProfanityCheck))
+	mux.HandleFunc("PUT /admin/profanity/{language}", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminProfanityReplace))
+	mux.HandleFunc("POST /admin/profanity/{language}/words", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminProfanityAdd))
+	mux.HandleFunc("DELETE /admin/profanity/{language}/words/{word}", apiCfg.middlewareRequireRole(adminOnly, apiCfg.handlerAdminProfanityRemove))
 	// User APIs
 	//	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreateV2)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersPatch)
+	mux.HandleFunc("PATCH /api/users/me", apiCfg.handlerUsersPatch)
+	mux.HandleFunc("DELETE /api/users/me", apiCfg.handlerUsersDelete)
+	mux.HandleFunc("POST /api/users/me/restore", apiCfg.handlerUsersRestore)
+	mux.HandleFunc("GET /api/users/me/export", apiCfg.handlerUsersExport)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerUsersVerify)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	mux.HandleFunc("POST /api/login/magic", apiCfg.handlerLoginMagic)
+	mux.HandleFunc("POST /api/login/magic/verify", apiCfg.handlerLoginMagicVerify)
+	mux.HandleFunc("GET /api/oidc/login", apiCfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/callback", apiCfg.handlerOIDCCallback)
+	mux.HandleFunc("POST /api/mfa/totp/enroll", apiCfg.handlerTOTPEnroll)
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.handlerTOTPConfirm)
+	mux.HandleFunc("POST /api/webauthn/register/begin", apiCfg.handlerWebAuthnRegisterBegin)
+	mux.HandleFunc("POST /api/webauthn/register/finish", apiCfg.handlerWebAuthnRegisterFinish)
+	mux.HandleFunc("POST /api/webauthn/login/begin", apiCfg.handlerWebAuthnLoginBegin)
+	mux.HandleFunc("POST /api/webauthn/login/finish", apiCfg.handlerWebAuthnLoginFinish)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
 	// Chirp APIs
 	//	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsGetAll)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsGetAllV2)
@@ -86,10 +281,24 @@ func main() {
 	// Token APIs
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerTokenRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerTokenRevoke)
+	// API key APIs
+	mux.HandleFunc("POST /api/keys", apiCfg.handlerAPIKeysCreate)
+	mux.HandleFunc("GET /api/keys", apiCfg.handlerAPIKeysGet)
+	mux.HandleFunc("DELETE /api/keys/{keyID}", apiCfg.handlerAPIKeysDelete)
+	// Session APIs
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerSessionsGet)
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.handlerSessionsRevokeAll)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerSessionsRevoke)
 	// Admin APIs
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.HandleFunc("GET /admin/metrics", apiCfg.middlewareRequireRole(staffOnly, apiCfg.handlerMetrics))
 	// Webhooks
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.webhookPolka)
+	// Outgoing webhooks
+	mux.HandleFunc("POST /api/webhooks", apiCfg.handlerWebhooksCreate)
+	mux.HandleFunc("GET /api/webhooks", apiCfg.handlerWebhooksGet)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", apiCfg.handlerWebhooksDelete)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", apiCfg.handlerWebhookDeliveriesGet)
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", apiCfg.handlerWebhookRedeliver)
 	// Update the multiplexer to accept CORS data
 	corsMux := middlewareCors(mux)
 	// Setup a server that uses the new multiplexer
@@ -97,13 +306,103 @@ func main() {
 		Addr:    "localhost:8080",
 		Handler: corsMux,
 	}
-	log.Fatal(server.ListenAndServe())
+
+	// Stop the server and background jobs on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runPeriodic(ctx, sweepInterval, func(ctx context.Context) {
+			apiCfg.sweep(ctx, retention)
+		})
+	}()
+	wg.Add(6)
+	go func() {
+		defer wg.Done()
+		runPeriodic(ctx, time.Hour, apiCfg.purgeDeletedAccounts)
+	}()
+	go func() {
+		defer wg.Done()
+		runPeriodic(ctx, envDuration("SUBSCRIPTION_EXPIRY_INTERVAL", 15*time.Minute), apiCfg.expireSubscriptions)
+	}()
+	go func() {
+		defer wg.Done()
+		runPeriodic(ctx, envDuration("WEBHOOK_DISPATCH_INTERVAL", 5*time.Second), apiCfg.dispatchWebhooks)
+	}()
+	go func() {
+		defer wg.Done()
+		apiCfg.jobs.Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		apiCfg.outbox.Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		runPeriodic(ctx, envDuration("PROFANITY_RELOAD_INTERVAL", 30*time.Second), apiCfg.reloadProfanity)
+	}()
+
+	go func() {
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %s", err)
+	}
+	wg.Wait()
+}
+
+// newMailer sends mail through SMTP when MAILER=smtp, otherwise it writes messages to MAIL_DIR.
+// The default MAIL_DIR is outside the directory served at /app, which would expose every token mailed.
+func newMailer() mailer.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@chirpy.local"
+	}
+	if os.Getenv("MAILER") == "smtp" {
+		return &mailer.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "chirpy", "mail")
+	}
+	return &mailer.DirMailer{Dir: dir, From: from}
+}
+
+// envDuration reads a duration such as "30m" from the environment, or returns fallback if unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid duration for %s: %q, using %s", key, val, fallback)
+		return fallback
+	}
+	return d
 }
 
 func middlewareCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
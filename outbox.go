@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/database"
+	database2 "github.com/ethpalser/chirpy/internal/database/v2"
+	"github.com/ethpalser/chirpy/internal/outbox"
+)
+
+// newOutbox dispatches the outboxes of both the json db and postgres to the subscribers of each event
+func (cfg *apiConfig) newOutbox() *outbox.Dispatcher {
+	dispatcher := outbox.NewDispatcher(outbox.Options{
+		PollInterval: envDuration("OUTBOX_POLL_INTERVAL", 5*time.Second),
+		BatchSize:    100,
+		Timeout:      30 * time.Second,
+		RetryBase:    5 * time.Second,
+		RetryMax:     time.Hour,
+	}, &cfg.database, outbox.NewPostgresStore(cfg.dbQueries))
+
+	dispatcher.Subscribe(eventChirpCreated, "webhooks", cfg.deliverOutboxEvent)
+	dispatcher.Subscribe(eventChirpDeleted, "webhooks", cfg.deliverOutboxEvent)
+	return dispatcher
+}
+
+// chirpEvent builds the outbox event of a json db chirp write
+func chirpEvent(eventType string) database.ChirpEvent {
+	return func(chirp database.Chirp) (outbox.Event, error) {
+		return outbox.NewEvent(eventType, fmt.Sprint(chirp.AuthorID), ChirpView{
+			ID:       chirp.ID,
+			Body:     chirp.Message,
+			AuthorID: chirp.AuthorID,
+		})
+	}
+}
+
+// createChirpV2 creates the chirp and its chirp.created event in one transaction. The event is about
+// authorID, the json db user, as webhook endpoints are owned by json db users.
+func (cfg *apiConfig) createChirpV2(ctx context.Context, authorID int, args database2.CreateChirpParams) (database2.Chirp, error) {
+	tx, err := cfg.pgDB.BeginTx(ctx, nil)
+	if err != nil {
+		return database2.Chirp{}, err
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	dbChirp, err := queries.CreateChirp(ctx, args)
+	if err != nil {
+		return database2.Chirp{}, err
+	}
+	event, err := outbox.NewEvent(eventChirpCreated, fmt.Sprint(authorID), ChirpView{
+		UUID:      dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		Body:      dbChirp.Body,
+		UserID:    dbChirp.UserID,
+	})
+	if err != nil {
+		return database2.Chirp{}, err
+	}
+	err = outbox.Insert(ctx, queries, event)
+	if err != nil {
+		return database2.Chirp{}, err
+	}
+	return dbChirp, tx.Commit()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/chirptext"
+	"github.com/ethpalser/chirpy/internal/profanity"
+)
+
+var errProfanity = errors.New("chirp contains words that aren't allowed")
+
+// newProfanityFilter loads the word lists in PROFANITY_DIR and reads how chirps with listed words
+// are handled from PROFANITY_MODE, censoring them by default
+func newProfanityFilter() (*profanity.Filter, string) {
+	dir := os.Getenv("PROFANITY_DIR")
+	if dir == "" {
+		dir = "profanity"
+	}
+	filter, err := profanity.Load(dir)
+	if err != nil {
+		log.Fatalf("Error loading word lists: %s", err)
+	}
+
+	mode := os.Getenv("PROFANITY_MODE")
+	if mode == "" {
+		mode = profanity.ModeReplace
+	}
+	if !profanity.ValidMode(mode) {
+		log.Printf("Invalid PROFANITY_MODE %q, using %s", mode, profanity.ModeReplace)
+		mode = profanity.ModeReplace
+	}
+	return filter, mode
+}
+
+// reloadProfanity picks up word lists edited on disk. A list that can't be read keeps the ones in use.
+func (cfg *apiConfig) reloadProfanity(ctx context.Context) {
+	reloaded, err := cfg.profanity.ReloadIfChanged()
+	if err != nil {
+		log.Printf("Failed to reload word lists: %s", err)
+		return
+	}
+	if reloaded {
+		log.Println("Reloaded word lists")
+	}
+}
+
+// validateChirp cleans the chirp and checks it against the author's rules, then handles listed
+// words by the profanity mode. In flag mode the chirp is kept as written and the matches are
+// returned for flagChirp.
+func (cfg *apiConfig) validateChirp(msg string, rules chirptext.Rules) (string, []profanity.Match, error) {
+	msg, err := chirptext.Clean(msg, rules)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch cfg.profanityMode {
+	case profanity.ModeReject:
+		if len(cfg.profanity.Find(msg)) > 0 {
+			return "", nil, errProfanity
+		}
+		return msg, nil, nil
+	case profanity.ModeFlag:
+		return msg, cfg.profanity.Find(msg), nil
+	default:
+		cleaned, _ := cfg.profanity.Censor(msg)
+		return cleaned, nil, nil
+	}
+}
+
+// flagChirp records a chirp with listed words in the audit log for moderators to review
+func (cfg *apiConfig) flagChirp(r *http.Request, actor audit.Actor, chirpID string, matches []profanity.Match) {
+	if len(matches) == 0 {
+		return
+	}
+	words := make([]string, len(matches))
+	for i, match := range matches {
+		words[i] = match.Word
+	}
+	ctx := withAuditReason(withAuditActor(r, actor), "listed words: "+strings.Join(words, ", "))
+	cfg.recordAudit(ctx, auditChirpFlagged, audit.Target{Type: targetChirp, ID: chirpID}, nil)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/auth"
+	"github.com/ethpalser/chirpy/internal/database"
+)
+
+var errMissingAuth = errors.New("invalid auth token")
+var errInsufficientScope = errors.New("api key is missing the required scope")
+var errAccountSuspended = errors.New("account is suspended")
+
+// getAuthClaims reads the 'Authorization: Bearer <token>' header and returns the claims of the access token
+func (cfg *apiConfig) getAuthClaims(r *http.Request) (*auth.Claims, error) {
+	claims, _, err := cfg.getAuthUser(r)
+	return claims, err
+}
+
+// getAuthUser returns the claims of the request's access token along with the user as stored now.
+// Decisions about the user, such as their role, are made on the stored user and not the claims.
+func (cfg *apiConfig) getAuthUser(r *http.Request) (*auth.Claims, database.User, error) {
+	accessToken := r.Header.Get("Authorization")
+	if !strings.HasPrefix(accessToken, "Bearer ") {
+		return nil, database.User{}, errMissingAuth
+	}
+	tokenVal := strings.TrimPrefix(accessToken, "Bearer ")
+	claims, err := auth.ParseClaims(cfg.jwtSecret, tokenVal)
+	if err != nil {
+		return nil, database.User{}, err
+	}
+
+	// A signature is not enough, tokens issued before the user's token version was bumped are revoked
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, database.User{}, errMissingAuth
+	}
+	dbUser, err := cfg.database.GetUser(userID)
+	if err != nil || dbUser.TokenVersion != claims.Version {
+		return nil, database.User{}, errMissingAuth
+	}
+	// Nor are tokens of a session that was signed out or revoked
+	_, err = cfg.database.GetSession(userID, claims.SessionID)
+	if err != nil {
+		return nil, database.User{}, errMissingAuth
+	}
+	if dbUser.Suspension.Active(time.Now()) {
+		return nil, database.User{}, errAccountSuspended
+	}
+	return claims, dbUser, nil
+}
+
+// getAuthUserID returns the user id of the request's access token
+func (cfg *apiConfig) getAuthUserID(r *http.Request) (int, error) {
+	claims, err := cfg.getAuthClaims(r)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(claims.Subject)
+}
+
+// authorize accepts either an access token, which may do anything the user can, or
+// an 'Authorization: ApiKey <key>' header for a key that was granted scope
+func (cfg *apiConfig) authorize(r *http.Request, scope string) (int, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "ApiKey ") {
+		return cfg.getAuthUserID(r)
+	}
+
+	key := strings.TrimPrefix(header, "ApiKey ")
+	id, ok := auth.ParseAPIKeyID(key)
+	if !ok {
+		return 0, errMissingAuth
+	}
+	dbKey, err := cfg.database.UseAPIKey(id, auth.HashToken(key))
+	if err != nil {
+		return 0, errMissingAuth
+	}
+	if !slices.Contains(dbKey.Scopes, scope) {
+		return 0, errInsufficientScope
+	}
+	dbUser, err := cfg.database.GetUser(dbKey.UserID)
+	if err != nil {
+		return 0, errMissingAuth
+	}
+	if dbUser.Suspension.Active(time.Now()) {
+		return 0, errAccountSuspended
+	}
+	return dbKey.UserID, nil
+}
+
+// authorizeAPIKey checks the scope of an 'Authorization: ApiKey <key>' header if one is sent,
+// for public endpoints that keys can also call. Other requests are let through.
+func (cfg *apiConfig) authorizeAPIKey(r *http.Request, scope string) error {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		return nil
+	}
+	_, err := cfg.authorize(r, scope)
+	return err
+}
+
+// middlewareRequireRole only lets through requests with an access token of a user who currently
+// has one of the roles, the role in the token may be out of date
+func (cfg *apiConfig) middlewareRequireRole(roles []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, dbUser, err := cfg.getAuthUser(r)
+		if err != nil {
+			responseWithError(w, authErrorStatus(err), "unauthorized access")
+			return
+		}
+		if !slices.Contains(roles, dbUser.Role) {
+			responseWithError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// authErrorStatus is 403 when the caller is known but not allowed, otherwise 401
+func authErrorStatus(err error) int {
+	if errors.Is(err, errInsufficientScope) || errors.Is(err, errAccountSuspended) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// clientIP returns the remote address of the request without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
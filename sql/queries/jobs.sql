@@ -0,0 +1,57 @@
+-- name: EnqueueJob :one
+INSERT INTO jobs (id, created_at, updated_at, type, payload, status, attempts, max_attempts, run_at)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	NOW(),
+	$1,
+	$2,
+	'queued',
+	0,
+	$3,
+	$4
+)
+RETURNING *;
+
+-- name: ClaimJob :one
+-- Workers skip jobs locked by another worker's claim rather than waiting on them
+UPDATE jobs
+SET status = 'running', attempts = attempts + 1, locked_until = $2, updated_at = NOW()
+WHERE id = (
+	SELECT id FROM jobs
+	WHERE (status = 'queued' AND run_at <= $1) OR (status = 'running' AND locked_until < $1)
+	ORDER BY run_at
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteJob :exec
+UPDATE jobs
+SET status = 'succeeded', locked_until = NULL, last_error = '', updated_at = NOW()
+WHERE id = $1;
+
+-- name: FailJob :exec
+UPDATE jobs
+SET status = $2, run_at = $3, locked_until = NULL, last_error = $4, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetJob :one
+SELECT * FROM jobs
+WHERE id = $1;
+
+-- name: ListJobs :many
+SELECT * FROM jobs
+WHERE sqlc.arg(status)::text = '' OR status = sqlc.arg(status)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: RetryJob :one
+UPDATE jobs
+SET status = 'queued', attempts = 0, run_at = NOW(), last_error = '', updated_at = NOW()
+WHERE id = $1 AND status <> 'running'
+RETURNING *;
+
+-- name: DeleteSucceededJobs :execrows
+DELETE FROM jobs
+WHERE status = 'succeeded' AND updated_at < $1;
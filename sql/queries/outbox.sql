@@ -0,0 +1,38 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO outbox_events (id, created_at, type, subject, payload, attempts, available_at)
+VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$5,
+	0,
+	$2
+);
+
+-- name: ClaimOutboxEvents :many
+-- Dispatchers skip events locked by another dispatcher's claim rather than waiting on them
+UPDATE outbox_events
+SET attempts = attempts + 1, available_at = sqlc.arg(locked_until)
+WHERE id IN (
+	SELECT id FROM outbox_events
+	WHERE dispatched_at IS NULL AND available_at <= sqlc.arg(now)
+	ORDER BY created_at
+	LIMIT sqlc.arg(max_rows)
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkOutboxEventDispatched :exec
+UPDATE outbox_events
+SET dispatched_at = NOW(), last_error = ''
+WHERE id = $1;
+
+-- name: MarkOutboxEventFailed :exec
+UPDATE outbox_events
+SET available_at = $2, last_error = $3
+WHERE id = $1;
+
+-- name: DeleteDispatchedOutboxEvents :execrows
+DELETE FROM outbox_events
+WHERE dispatched_at < $1;
@@ -0,0 +1,43 @@
+-- name: GetSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: GetSubscriptionForUpdate :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_end, grace_until, last_event_at)
+VALUES (
+	$1,
+	NOW(),
+	NOW(),
+	$2,
+	$3,
+	$4,
+	$5,
+	$6
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(),
+	plan = EXCLUDED.plan,
+	status = EXCLUDED.status,
+	current_period_end = EXCLUDED.current_period_end,
+	grace_until = EXCLUDED.grace_until,
+	last_event_at = EXCLUDED.last_event_at
+RETURNING *;
+
+-- name: CreateSubscriptionEvent :exec
+INSERT INTO subscription_events (id, created_at, user_id, event, status)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2,
+	$3
+);
+
+-- name: GetOpenSubscriptions :many
+SELECT * FROM subscriptions
+WHERE status IN ('active', 'past_due') AND current_period_end < $1;
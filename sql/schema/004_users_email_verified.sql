@@ -0,0 +1,11 @@
+-- +goose Up
+-- Accounts created before verification existed are treated as verified
+ALTER TABLE users
+ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE users
+ALTER COLUMN email_verified SET DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN email_verified;
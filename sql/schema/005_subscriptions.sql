@@ -0,0 +1,35 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN is_chirpy_red BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE subscriptions(
+	user_id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	plan TEXT NOT NULL,
+	status TEXT NOT NULL,
+	current_period_end TIMESTAMP NOT NULL,
+	grace_until TIMESTAMP,
+	CONSTRAINT fk_users_subscriptions
+		FOREIGN KEY(user_id)
+		REFERENCES users(id)
+		ON DELETE CASCADE
+);
+
+CREATE TABLE subscription_events(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL,
+	event TEXT NOT NULL,
+	status TEXT NOT NULL,
+	CONSTRAINT fk_users_subscription_events
+		FOREIGN KEY(user_id)
+		REFERENCES users(id)
+		ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE subscription_events;
+DROP TABLE subscriptions;
+ALTER TABLE users
+DROP COLUMN is_chirpy_red;
@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE jobs(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	type TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMP NOT NULL,
+	locked_until TIMESTAMP,
+	last_error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_jobs_status_run_at ON jobs(status, run_at);
+
+-- +goose Down
+DROP TABLE jobs;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE outbox_events(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	type TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	available_at TIMESTAMP NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	dispatched_at TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_events_pending ON outbox_events(available_at) WHERE dispatched_at IS NULL;
+
+-- +goose Down
+DROP TABLE outbox_events;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE subscriptions
+ADD COLUMN last_event_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE subscriptions
+DROP COLUMN last_event_at;
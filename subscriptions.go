@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/billing"
+	"github.com/ethpalser/chirpy/internal/database"
+	database2 "github.com/ethpalser/chirpy/internal/database/v2"
+	"github.com/google/uuid"
+)
+
+// updateSubscription applies the event to a json db user's subscription and updates their Chirpy Red status
+func (cfg *apiConfig) updateSubscription(ctx context.Context, userID int, event string, change billing.Change) error {
+	now := time.Now()
+	current, next, err := cfg.database.UpdateSubscription(userID, event, func(current billing.Subscription) (billing.Subscription, bool, error) {
+		next, err := cfg.billingPolicy.Apply(current, event, change, now)
+		if err != nil {
+			return billing.Subscription{}, false, err
+		}
+		return next, cfg.billingPolicy.Premium(next, now), nil
+	})
+	if err != nil {
+		return err
+	}
+	cfg.recordAudit(ctx, auditSubscriptionChanged, userTarget(userID), audit.Diff(subscriptionFields(current), subscriptionFields(next)))
+	return nil
+}
+
+// updateSubscriptionV2 is updateSubscription for a postgres user, in one transaction
+func (cfg *apiConfig) updateSubscriptionV2(ctx context.Context, userID uuid.UUID, event string, change billing.Change) error {
+	tx, err := cfg.pgDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	// Locking the user serializes events for them even before they have a subscription to lock
+	user, err := queries.GetUserForUpdate(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	current := billing.Subscription{}
+	dbSub, err := queries.GetSubscriptionForUpdate(ctx, userID)
+	if err == nil {
+		current = billing.Subscription{
+			Plan:             dbSub.Plan,
+			Status:           dbSub.Status,
+			CurrentPeriodEnd: dbSub.CurrentPeriodEnd,
+		}
+		if dbSub.GraceUntil.Valid {
+			current.GraceUntil = &dbSub.GraceUntil.Time
+		}
+		if dbSub.LastEventAt.Valid {
+			current.LastEventAt = &dbSub.LastEventAt.Time
+		}
+	} else if errors.Is(err, sql.ErrNoRows) && user.IsChirpyRed {
+		current = billing.Legacy(now)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	next, err := cfg.billingPolicy.Apply(current, event, change, now)
+	if err != nil {
+		return err
+	}
+
+	params := database2.UpsertSubscriptionParams{
+		UserID:           userID,
+		Plan:             next.Plan,
+		Status:           next.Status,
+		CurrentPeriodEnd: next.CurrentPeriodEnd,
+	}
+	if next.GraceUntil != nil {
+		params.GraceUntil = sql.NullTime{Time: *next.GraceUntil, Valid: true}
+	}
+	if next.LastEventAt != nil {
+		params.LastEventAt = sql.NullTime{Time: *next.LastEventAt, Valid: true}
+	}
+	_, err = queries.UpsertSubscription(ctx, params)
+	if err != nil {
+		return err
+	}
+	err = queries.CreateSubscriptionEvent(ctx, database2.CreateSubscriptionEventParams{
+		UserID: userID,
+		Event:  event,
+		Status: next.Status,
+	})
+	if err != nil {
+		return err
+	}
+	err = queries.SetUserChirpyRed(ctx, database2.SetUserChirpyRedParams{
+		ID:          userID,
+		IsChirpyRed: cfg.billingPolicy.Premium(next, now),
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	cfg.recordAudit(ctx, auditSubscriptionChanged, audit.Target{Type: targetUser, ID: userID.String()}, audit.Diff(subscriptionFields(current), subscriptionFields(next)))
+	return nil
+}
+
+// subscriptionFields are the parts of a subscription compared for the audit log
+func subscriptionFields(sub billing.Subscription) map[string]interface{} {
+	fields := map[string]interface{}{
+		"plan":               sub.Plan,
+		"status":             sub.Status,
+		"current_period_end": "",
+		"grace_until":        "",
+	}
+	if !sub.CurrentPeriodEnd.IsZero() {
+		fields["current_period_end"] = sub.CurrentPeriodEnd.UTC().Format(time.RFC3339)
+	}
+	if sub.GraceUntil != nil {
+		fields["grace_until"] = sub.GraceUntil.UTC().Format(time.RFC3339)
+	}
+	return fields
+}
+
+// expireSubscriptions ends subscriptions that are past their period and any grace, on both databases
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context) {
+	now := time.Now()
+	expired := 0
+
+	subs, err := cfg.database.GetOpenSubscriptions(now)
+	if err != nil {
+		log.Printf("Subscription expiry failed on json db: %s", err)
+	}
+	for _, sub := range subs {
+		if !cfg.billingPolicy.Lapsed(sub.Subscription, now) {
+			continue
+		}
+		err = cfg.updateSubscription(ctx, sub.UserID, billing.EventExpired, billing.Change{})
+		if err != nil {
+			log.Printf("Failed to expire subscription of user %d: %s", sub.UserID, err)
+			continue
+		}
+		expired++
+	}
+
+	subsV2, err := cfg.dbQueries.GetOpenSubscriptions(ctx, now)
+	if err != nil {
+		log.Printf("Subscription expiry failed on postgres: %s", err)
+	}
+	for _, sub := range subsV2 {
+		state := billing.Subscription{Status: sub.Status, CurrentPeriodEnd: sub.CurrentPeriodEnd}
+		if sub.GraceUntil.Valid {
+			state.GraceUntil = &sub.GraceUntil.Time
+		}
+		if !cfg.billingPolicy.Lapsed(state, now) {
+			continue
+		}
+		err = cfg.updateSubscriptionV2(ctx, sub.UserID, billing.EventExpired, billing.Change{})
+		if err != nil {
+			log.Printf("Failed to expire subscription of user %s: %s", sub.UserID, err)
+			continue
+		}
+		expired++
+	}
+
+	if expired > 0 {
+		log.Printf("Expired %d lapsed subscriptions", expired)
+	}
+}
+
+// applySubscriptionEvent routes an event to the database the user is in. Polka sends the
+// integer ids of json db users and the uuids of postgres users.
+func (cfg *apiConfig) applySubscriptionEvent(ctx context.Context, user interface{}, event string, change billing.Change) error {
+	var subject string
+	var err error
+	switch id := user.(type) {
+	case float64:
+		subject = fmt.Sprint(int(id))
+		err = cfg.updateSubscription(ctx, int(id), event, change)
+	case string:
+		userID, parseErr := uuid.Parse(id)
+		if parseErr != nil {
+			return fmt.Errorf("%w: user_id is not a valid id", errInvalidPolkaEvent)
+		}
+		subject = userID.String()
+		err = cfg.updateSubscriptionV2(ctx, userID, event, change)
+	default:
+		return fmt.Errorf("%w: user_id is not a valid id", errInvalidPolkaEvent)
+	}
+
+	if err == nil && event == billing.EventUpgraded {
+		if change.Plan == "" {
+			change.Plan = billing.PlanRed
+		}
+		cfg.emitEvent(eventUserUpgraded, subject, map[string]string{
+			"user_id": subject,
+			"plan":    change.Plan,
+		})
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runPeriodic calls task every interval until ctx is cancelled
+func runPeriodic(ctx context.Context, interval time.Duration, task func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			task(ctx)
+		}
+	}
+}
+
+// sweepRetention is how long each kind of finished record is kept before it is swept
+type sweepRetention struct {
+	Tokens time.Duration
+	Jobs   time.Duration
+	Outbox time.Duration
+}
+
+// sweep removes finished records older than their retention, stopping early once ctx is cancelled
+func (cfg *apiConfig) sweep(ctx context.Context, retention sweepRetention) {
+	sweeps := []func(){
+		func() { cfg.sweepTokens(ctx, retention.Tokens) },
+		func() { cfg.sweepJobs(ctx, retention.Jobs) },
+		func() { cfg.sweepOutbox(ctx, retention.Outbox) },
+	}
+	for _, sweep := range sweeps {
+		if ctx.Err() != nil {
+			return
+		}
+		sweep()
+	}
+}
+
+// sweepTokens removes refresh tokens that expired or were revoked longer ago than the retention period
+func (cfg *apiConfig) sweepTokens(ctx context.Context, retention time.Duration) {
+	removed, err := cfg.database.PurgeExpiredTokens(time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Token sweep failed: %s", err)
+		return
+	}
+	log.Printf("Token sweep removed %d expired or revoked tokens", removed)
+}
+
+// sweepJobs removes jobs that succeeded longer ago than the retention period
+func (cfg *apiConfig) sweepJobs(ctx context.Context, retention time.Duration) {
+	removed, err := cfg.jobs.Store().PurgeJobs(ctx, time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Job sweep failed: %s", err)
+		return
+	}
+	log.Printf("Job sweep removed %d finished jobs", removed)
+}
+
+// sweepOutbox removes outbox events dispatched longer ago than the retention period
+func (cfg *apiConfig) sweepOutbox(ctx context.Context, retention time.Duration) {
+	removed, err := cfg.outbox.Purge(ctx, time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Outbox sweep failed: %s", err)
+		return
+	}
+	log.Printf("Outbox sweep removed %d dispatched events", removed)
+}
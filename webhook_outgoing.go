@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/ethpalser/chirpy/internal/outbox"
+	"github.com/ethpalser/chirpy/internal/util"
+	"github.com/ethpalser/chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// Events that can be sent to registered webhook endpoints
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpDeleted = "chirp.deleted"
+	eventUserCreated  = "user.created"
+	eventUserUpgraded = "user.upgraded"
+)
+
+var webhookEvents = map[string]struct{}{
+	eventChirpCreated: {},
+	eventChirpDeleted: {},
+	eventUserCreated:  {},
+	eventUserUpgraded: {},
+}
+
+const (
+	maxDeliveryAttempts = 8
+	deliveryRetryBase   = 30 * time.Second
+	deliveryRetryMax    = 6 * time.Hour
+	deliveryTimeout     = 10 * time.Second
+	deliveryBatchSize   = 50
+)
+
+type webhookPayload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// emitEvent queues the event for every endpoint subscribed to it. subject is the id of the user
+// the event is about. Failing to queue is logged rather than failing the request that caused it.
+func (cfg *apiConfig) emitEvent(event string, subject string, data interface{}) {
+	eventID := uuid.NewString()
+	err := cfg.queueWebhookEvent(eventID, event, subject, time.Now().UTC(), data)
+	if err != nil {
+		log.Printf("Failed to queue %s event %s: %s", event, eventID, err)
+	}
+}
+
+// deliverOutboxEvent is the outbox subscriber for webhooks. Deliveries are keyed by the event id,
+// so an event dispatched again isn't sent twice.
+func (cfg *apiConfig) deliverOutboxEvent(ctx context.Context, event outbox.Event) error {
+	return cfg.queueWebhookEvent(event.ID, event.Type, event.Subject, event.CreatedAt, event.Payload)
+}
+
+func (cfg *apiConfig) queueWebhookEvent(eventID string, event string, subject string, createdAt time.Time, data interface{}) error {
+	payload := webhookPayload{
+		ID:        eventID,
+		Event:     event,
+		CreatedAt: createdAt,
+		Data:      data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = cfg.database.EnqueueWebhookEvent(eventID, event, subject, string(body))
+	return err
+}
+
+// dispatchWebhooks sends the deliveries that are due, scheduling a retry for any that fail
+func (cfg *apiConfig) dispatchWebhooks(ctx context.Context) {
+	now := time.Now()
+	due, endpoints, err := cfg.database.GetDueWebhookDeliveries(now, deliveryBatchSize)
+	if err != nil {
+		log.Printf("Failed to load webhook deliveries: %s", err)
+		return
+	}
+
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		endpoint := endpoints[delivery.EndpointID]
+
+		sendCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+		start := time.Now()
+		statusCode, sendErr := webhook.Send(sendCtx, cfg.webhookClient, endpoint.URL, endpoint.Secret, delivery.Event, delivery.ID, []byte(delivery.Payload))
+		cancel()
+
+		attempt := database.DeliveryAttempt{
+			At:         start,
+			StatusCode: statusCode,
+			Duration:   time.Since(start),
+		}
+		status := database.DeliveryDelivered
+		nextAttempt := time.Time{}
+		if sendErr != nil {
+			attempt.Error = sendErr.Error()
+			failures := len(delivery.Attempts) + 1
+			if failures >= maxDeliveryAttempts {
+				status = database.DeliveryFailed
+				log.Printf("Giving up on webhook delivery %s to %s after %d attempts: %s", delivery.ID, endpoint.URL, failures, sendErr)
+			} else {
+				status = database.DeliveryPending
+				nextAttempt = time.Now().Add(util.Backoff(failures, deliveryRetryBase, deliveryRetryMax))
+			}
+		}
+
+		err = cfg.database.RecordDeliveryAttempt(delivery.ID, attempt, status, nextAttempt)
+		if err != nil {
+			log.Printf("Failed to record webhook delivery %s: %s", delivery.ID, err)
+		}
+	}
+}
+
+// newWebhookClient does not follow redirects, so a delivery only ever goes to the registered url,
+// and refuses to connect to private addresses unless they are allowed for local testing
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+	if !allowPrivate {
+		dialer.Control = webhook.DialControl
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
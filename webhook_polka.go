@@ -1,59 +1,246 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/ethpalser/chirpy/internal/audit"
+	"github.com/ethpalser/chirpy/internal/billing"
+	"github.com/ethpalser/chirpy/internal/database"
+	"github.com/ethpalser/chirpy/internal/webhook"
 )
 
+const polkaSource = "polka"
+
+// maxWebhookBody bounds how much of a webhook request is read and stored
+const maxWebhookBody = 1 << 20
+
+// polkaClaimTimeout is how long an event can stay received before it is assumed its delivery
+// crashed mid-way, and a retry applies it
+const polkaClaimTimeout = 5 * time.Minute
+
+// errInvalidPolkaEvent is an event that can never be applied, so retrying it won't help
+var errInvalidPolkaEvent = errors.New("invalid polka event")
+
+type PolkaEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	// when Polka created the event, events without it are applied in the order they arrive
+	CreatedAt *time.Time             `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+type WebhookEventView struct {
+	ID          string          `json:"id"`
+	Event       string          `json:"event"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int             `json:"attempts"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
 func (cfg *apiConfig) webhookPolka(w http.ResponseWriter, r *http.Request) {
-	type WebhookRequest struct {
-		Event string                 `json:"event"`
-		Data  map[string]interface{} `json:"data"`
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request: failed reading body")
+		return
 	}
 
-	accessToken := r.Header.Get("Authorization")
-	if accessToken == "" {
-		responseWithError(w, http.StatusUnauthorized, "invalid auth token")
+	authErr := cfg.authenticatePolka(r, body)
+	if authErr != nil {
+		responseWithError(w, http.StatusUnauthorized, authErr.Error())
 		return
 	}
-	tokenVal := strings.TrimPrefix(accessToken, "ApiKey ")
-	if tokenVal != cfg.polkaApiKey {
-		responseWithError(w, http.StatusUnauthorized, "unauthorized access")
+
+	params := PolkaEvent{}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		responseWithError(w, http.StatusBadRequest, "invalid request: body is not a polka event")
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := WebhookRequest{}
-	err := decoder.Decode(&params)
+	// Without an id from Polka, an exact retry of the same body is still recognised
+	eventID := params.ID
+	if eventID == "" {
+		eventID = r.Header.Get("Polka-Event-Id")
+	}
+	if eventID == "" {
+		sum := sha256.Sum256(body)
+		eventID = hex.EncodeToString(sum[:])
+	}
+
+	event, err := cfg.database.RecordWebhookEvent(database.WebhookEvent{
+		ID:      eventID,
+		Source:  polkaSource,
+		Event:   params.Event,
+		Payload: string(body),
+		Status:  database.WebhookEventReceived,
+	}, polkaClaimTimeout)
+	if errors.Is(err, database.ErrConflict) {
+		// Already applied, or being applied by a concurrent delivery
+		responseWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
 	if err != nil {
-		responseWithError(w, http.StatusInternalServerError, "something went wrong: failed reading request")
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
 		return
 	}
 
-	if params.Event != "user.upgraded" {
-		responseWithJSON(w, http.StatusNoContent, nil)
+	ctx := withAuditActor(r, audit.Actor{Type: audit.ActorWebhook, ID: polkaSource})
+	event, err = cfg.processPolkaEvent(ctx, event)
+	if errors.Is(err, errInvalidPolkaEvent) {
+		responseWithError(w, http.StatusBadRequest, event.Error)
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, "something went wrong")
 		return
 	}
+	responseWithJSON(w, http.StatusNoContent, nil)
+}
+
+// authenticatePolka checks the request's HMAC signature. Until a signing secret is configured,
+// the static api key Polka used before signing is still accepted.
+func (cfg *apiConfig) authenticatePolka(r *http.Request, body []byte) error {
+	if cfg.polkaWebhookSecret != "" {
+		return webhook.Verify(cfg.polkaWebhookSecret, r.Header.Get("Polka-Signature"), body, cfg.polkaWebhookTolerance, time.Now())
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "ApiKey ") {
+		return errMissingAuth
+	}
+	tokenVal := strings.TrimPrefix(header, "ApiKey ")
+	if cfg.polkaApiKey == "" || subtle.ConstantTimeCompare([]byte(tokenVal), []byte(cfg.polkaApiKey)) != 1 {
+		return errors.New("unauthorized access")
+	}
+	return nil
+}
 
-	dataUserID, ok := params.Data["user_id"]
+// processPolkaEvent applies a recorded event and stores the outcome in the ledger. Changes it
+// makes are audited as caused by the event.
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, event database.WebhookEvent) (database.WebhookEvent, error) {
+	ctx = withAuditReason(ctx, fmt.Sprintf("%s event %s (%s)", event.Source, event.ID, event.Event))
+	status, applyErr := cfg.applyPolkaEvent(ctx, []byte(event.Payload))
+	errMsg := ""
+	if applyErr != nil {
+		status = database.WebhookEventFailed
+		errMsg = applyErr.Error()
+		log.Printf("Polka event %s failed: %s", event.ID, applyErr)
+	}
+
+	finished, err := cfg.database.FinishWebhookEvent(event.Source, event.ID, status, errMsg)
+	if err != nil {
+		return event, err
+	}
+	return finished, applyErr
+}
+
+// applyPolkaEvent makes the changes for an event, returning whether it was processed or ignored
+func (cfg *apiConfig) applyPolkaEvent(ctx context.Context, payload []byte) (string, error) {
+	params := PolkaEvent{}
+	err := json.Unmarshal(payload, &params)
+	if err != nil {
+		return "", errInvalidPolkaEvent
+	}
+
+	switch params.Event {
+	case billing.EventUpgraded, billing.EventDowngraded, billing.EventPaymentFailed, billing.EventRenewed:
+	default:
+		return database.WebhookEventIgnored, nil
+	}
+
+	userID, ok := params.Data["user_id"]
 	if !ok {
-		responseWithError(w, http.StatusBadRequest, "invalid request: missing user_id")
+		return "", fmt.Errorf("%w: missing user_id", errInvalidPolkaEvent)
+	}
+	change := billing.Change{At: params.CreatedAt}
+	if plan, ok := params.Data["plan"].(string); ok {
+		change.Plan = plan
+	}
+	if periodEnd, ok := params.Data["current_period_end"].(string); ok {
+		at, err := time.Parse(time.RFC3339, periodEnd)
+		if err != nil {
+			return "", fmt.Errorf("%w: current_period_end is not an RFC 3339 time", errInvalidPolkaEvent)
+		}
+		change.PeriodEnd = &at
+	}
+
+	err = cfg.applySubscriptionEvent(ctx, userID, params.Event, change)
+	// Such as a failed payment for a user who already cancelled, or an upgrade that arrived
+	// after a later downgrade
+	if errors.Is(err, billing.ErrNotSubscribed) || errors.Is(err, billing.ErrStaleEvent) {
+		return database.WebhookEventIgnored, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return database.WebhookEventProcessed, nil
+}
+
+// handlerAdminPolkaEvents lists received Polka events, optionally filtered by ?status=
+func (cfg *apiConfig) handlerAdminPolkaEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := cfg.database.GetWebhookEvents(polkaSource, r.URL.Query().Get("status"))
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Numbers are decoded into float64
-	floatUserID, ok := dataUserID.(float64)
-	if !ok {
-		responseWithError(w, http.StatusBadRequest, "invalid request: user_id is not a number")
+	views := make([]WebhookEventView, 0, len(events))
+	for _, event := range events {
+		views = append(views, webhookEventView(event))
+	}
+	responseWithJSON(w, http.StatusOK, views)
+}
+
+// handlerAdminPolkaReplay applies a stored event again from its raw payload
+func (cfg *apiConfig) handlerAdminPolkaReplay(w http.ResponseWriter, r *http.Request) {
+	event, err := cfg.database.GetWebhookEvent(polkaSource, r.PathValue("eventID"))
+	if errors.Is(err, database.ErrNotExist) {
+		responseWithError(w, http.StatusNotFound, "event not found")
+		return
+	}
+	if err != nil {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	updErr := cfg.database.UpdateUserPremiumRed(int(floatUserID), true)
-	if updErr != nil {
-		responseWithError(w, http.StatusInternalServerError, "something went wrong")
+	// The outcome, even a failure, is part of the returned event
+	actor := cfg.requestActor(r)
+	before := event.Status
+	event, err = cfg.processPolkaEvent(withAuditActor(r, actor), event)
+	if err != nil && event.Status != database.WebhookEventFailed {
+		responseWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	cfg.auditRequest(r, actor, auditWebhookReplayed, audit.Target{Type: targetWebhookEvent, ID: event.Source + ":" + event.ID}, audit.Diff(
+		map[string]interface{}{"status": before},
+		map[string]interface{}{"status": event.Status},
+	))
+	responseWithJSON(w, http.StatusOK, webhookEventView(event))
+}
 
-	responseWithJSON(w, http.StatusNoContent, nil)
+func webhookEventView(event database.WebhookEvent) WebhookEventView {
+	return WebhookEventView{
+		ID:          event.ID,
+		Event:       event.Event,
+		Status:      event.Status,
+		Error:       event.Error,
+		Attempts:    event.Attempts,
+		ReceivedAt:  event.ReceivedAt,
+		ProcessedAt: event.ProcessedAt,
+		Payload:     json.RawMessage(event.Payload),
+	}
 }